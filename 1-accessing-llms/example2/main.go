@@ -7,6 +7,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
 	"github.com/predictionguard/go-client"
 )
 
@@ -28,31 +29,35 @@ func run() error {
 		log.Println(s)
 	}
 
-	cln := client.New(logger, host, apiKey)
+	cln := llm.NewClient(logger, host, apiKey)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	prompt := `<|im_start|>system
-You are a helpful code assistant.<|im_end|>
-<|im_start|>user
-Write a Go program that prints out random numbers.<|im_end|>
-<|im_start|>assistant<|im_end|>
-`
-
-	input := client.CompletionInput{
-		Model:       client.Models.Hermes2ProLlama38B,
-		Prompt:      prompt,
-		MaxTokens:   1000,
-		Temperature: 0.3,
+	// The completions endpoint takes a raw prompt, so the chat is
+	// rendered in the prompt format of the model.
+	spec, err := llm.DefaultRegistry.Lookup(client.Models.Hermes2ProLlama38B.String(), llm.OpCompletion)
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+
+	messages := []client.ChatInputMessage{
+		{
+			Role:    client.Roles.System,
+			Content: "You are a helpful code assistant.",
+		},
+		{
+			Role:    client.Roles.User,
+			Content: "Write a Go program that prints out random numbers.",
+		},
 	}
 
-	resp, err := cln.Completions(ctx, input)
+	answer, err := llm.ChatCompletion(ctx, cln, spec, messages, spec.Defaults)
 	if err != nil {
 		return fmt.Errorf("ERROR: %w", err)
 	}
 
-	fmt.Println(resp.Choices[0].Text)
+	fmt.Println(answer)
 
 	return nil
 }
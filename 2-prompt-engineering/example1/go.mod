@@ -1,5 +1,17 @@
 module github.com/dwhitena/go-genai-webinar/prompt-enigneering/example1
 
-go 1.22.3
+go 1.23
 
-require github.com/predictionguard/go-client v0.13.0 // indirect
+require (
+	github.com/dwhitena/go-genai-webinar/genai v0.0.0
+	github.com/predictionguard/go-client v0.13.0
+)
+
+require (
+	github.com/JohannesKaufmann/html-to-markdown v1.6.0 // indirect
+	github.com/PuerkitoBio/goquery v1.9.2 // indirect
+	github.com/andybalholm/cascadia v1.3.2 // indirect
+	golang.org/x/net v0.25.0 // indirect
+)
+
+replace github.com/dwhitena/go-genai-webinar/genai => ../../genai
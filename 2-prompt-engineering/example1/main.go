@@ -7,6 +7,8 @@ import (
 	"os"
 	"time"
 
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
 	"github.com/predictionguard/go-client"
 )
 
@@ -14,14 +16,6 @@ import (
 var host = "https://api.predictionguard.com"
 var apiKey = os.Getenv("PGKEY")
 
-// qAPromptTemplate is a template for a question and answer prompt.
-func qAPromptTemplate(context, question string) string {
-	return fmt.Sprintf(`Context: "%s"
-
-Question: "%s"
-`, context, question)
-}
-
 func run(query string) error {
 
 	logger := func(ctx context.Context, msg string, v ...any) {
@@ -43,21 +37,55 @@ func run(query string) error {
 		log.Fatal(err)
 	}
 
-	input := client.ChatSSEInput{
-		Model: client.Models.Hermes2ProLlama38B,
-		Messages: []client.ChatInputMessage{
-			{
-				Role:    client.Roles.System,
-				Content: "Read the context provided by the user and answer their question. If the question cannot be answered based on the context alone or the context does not explicitly say the answer to the question, respond \"Sorry I had trouble answering this question, based on the information I found\".",
-			},
-			{
-				Role: client.Roles.User,
-				Content: qAPromptTemplate(
-					string(promptContext),
-					query,
-				),
-			},
+	// Render the prompts with the context and the question.
+	system, err := prompt.Configured().Render("system", nil)
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	qa, err := prompt.Configured().Render("qa", map[string]any{
+		"context":  string(promptContext),
+		"question": query,
+	})
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+
+	// Load the worked examples and show the model the ones most similar
+	// to the question first, each asked about its own context through
+	// the same prompt as the question.
+	examples, err := rag.LoadExamples("examples.json")
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	fewShot := rag.FewShot{
+		Embedder: rag.Embedder{Client: cln},
+		Examples: examples,
+		K:        2,
+		Budget:   500,
+	}
+	if _, err := examples.Embed(ctx, fewShot.Embedder); err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	shots, err := fewShot.Messages(ctx, query, prompt.Configured(), "qa")
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+
+	messages := []client.ChatInputMessage{
+		{
+			Role:    client.Roles.System,
+			Content: system,
 		},
+	}
+	messages = append(messages, shots...)
+	messages = append(messages, client.ChatInputMessage{
+		Role:    client.Roles.User,
+		Content: qa,
+	})
+
+	input := client.ChatSSEInput{
+		Model:       client.Models.Hermes2ProLlama38B,
+		Messages:    messages,
 		MaxTokens:   1000,
 		Temperature: 0.3,
 	}
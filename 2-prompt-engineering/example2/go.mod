@@ -1,5 +1,10 @@
 module github.com/dwhitena/go-genai-webinar/prompt-enigneering/example2
 
-go 1.22.3
+go 1.23
 
-require github.com/predictionguard/go-client v0.13.0
+require (
+	github.com/dwhitena/go-genai-webinar/genai v0.0.0
+	github.com/predictionguard/go-client v0.13.0
+)
+
+replace github.com/dwhitena/go-genai-webinar/genai => ../../genai
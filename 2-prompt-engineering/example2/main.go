@@ -9,6 +9,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/longdoc"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
 	"github.com/predictionguard/go-client"
 )
 
@@ -16,14 +19,6 @@ import (
 var host = "https://api.predictionguard.com"
 var apiKey = os.Getenv("PGKEY")
 
-// qAPromptTemplate is a template for a question and answer prompt.
-func qAPromptTemplate(context, question string) string {
-	return fmt.Sprintf(`Context: "%s"
-
-Question: "%s"
-`, context, question)
-}
-
 func run(query, queryContext string) error {
 
 	logger := func(ctx context.Context, msg string, v ...any) {
@@ -39,19 +34,29 @@ func run(query, queryContext string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// Render the prompts with the context and the question.
+	system, err := prompt.Configured().Render("system", nil)
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	qa, err := prompt.Configured().Render("qa", map[string]any{
+		"context":  string(queryContext),
+		"question": query,
+	})
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+
 	input := client.ChatSSEInput{
 		Model: client.Models.Hermes2ProLlama38B,
 		Messages: []client.ChatInputMessage{
 			{
 				Role:    client.Roles.System,
-				Content: "Read the context provided by the user and answer their question. If the question cannot be answered based on the context alone or the context does not explicitly say the answer to the question, respond \"Sorry I had trouble answering this question, based on the information I found\".",
+				Content: system,
 			},
 			{
-				Role: client.Roles.User,
-				Content: qAPromptTemplate(
-					string(queryContext),
-					query,
-				),
+				Role:    client.Roles.User,
+				Content: qa,
 			},
 		},
 		MaxTokens:   1000,
@@ -60,7 +65,7 @@ func run(query, queryContext string) error {
 
 	ch := make(chan client.ChatSSE, 1000)
 
-	err := cln.ChatSSE(ctx, input, ch)
+	err = cln.ChatSSE(ctx, input, ch)
 	if err != nil {
 		return fmt.Errorf("ERROR: %w", err)
 	}
@@ -74,6 +79,52 @@ func run(query, queryContext string) error {
 	return nil
 }
 
+// contextBudget is the number of tokens of context that fit in a single
+// prompt, with room left for the question and the answer.
+const contextBudget = 6000
+
+// runLong answers a question about a context too long for a single
+// prompt, or summarizes it if the question is empty. The model takes
+// notes on the pieces of the context concurrently, and the notes are
+// reduced into the answer.
+func runLong(query, queryContext string) error {
+
+	logger := func(ctx context.Context, msg string, v ...any) {
+		s := fmt.Sprintf("msg: %s", msg)
+		for i := 0; i < len(v); i = i + 2 {
+			s = s + fmt.Sprintf(", %s: %v", v[i], v[i+1])
+		}
+		//log.Println(s)
+	}
+
+	cln := llm.NewClient(logger, host, apiKey)
+
+	spec, err := llm.DefaultRegistry.Lookup(client.Models.Hermes2ProLlama38B.String(), llm.OpChat)
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	chain := longdoc.Chain{
+		Client:      cln,
+		Model:       spec,
+		Prompts:     prompt.Configured(),
+		MaxTokens:   1000,
+		Temperature: 0.3,
+		Parallel:    4,
+	}
+
+	result, err := chain.MapReduce(ctx, queryContext, query)
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	fmt.Print(result.Answer)
+
+	return nil
+}
+
 func main() {
 
 	// Get the context file from a command line argument.
@@ -88,6 +139,14 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// A context that does not fit a single prompt is answered from in
+	// pieces.
+	long := llm.EstimateTokens(string(context)) > contextBudget
+	if long {
+		fmt.Println("The context is too long for a single prompt and is answered from in pieces.")
+		fmt.Println("Type \"summarize\" for a summary of it.")
+	}
+
 	// Start a cycle of listening for questions and responding to the questions.
 	fmt.Println("")
 	scanner := bufio.NewScanner(os.Stdin)
@@ -105,7 +164,15 @@ func main() {
 
 		// Print the bot response.
 		fmt.Print("\n🤖: ")
-		if err := run(input, string(context)); err != nil {
+		switch {
+		case long && strings.ToLower(input) == "summarize":
+			err = runLong("", string(context))
+		case long:
+			err = runLong(input, string(context))
+		default:
+			err = run(input, string(context))
+		}
+		if err != nil {
 			log.Fatalln(err)
 		}
 		fmt.Print("\n\n")
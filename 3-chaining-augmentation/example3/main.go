@@ -8,8 +8,10 @@ import (
 	"log"
 	"math"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
 	"github.com/predictionguard/go-client"
 )
 
@@ -39,21 +41,16 @@ func embed(imageLink string, text string) (*VectorizedChunk, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var image client.ImageNetwork
-	if imageLink != "" {
-		imageParsed, err := client.NewImageNetwork(imageLink)
-		if err != nil {
-			return nil, fmt.Errorf("ERROR: %w", err)
-		}
-		image = imageParsed
-	}
-
 	input := []client.EmbeddingInput{
 		{
 			Text: text,
 		},
 	}
 	if imageLink != "" {
+		image, err := rag.NewImage(imageLink)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: %w", err)
+		}
 		input[0].Image = image
 	}
 
@@ -132,9 +129,14 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Embed a question for the RAG answer.
+	// Embed a question for the RAG answer, which can be given on the
+	// command line and may include an image.
 	message := "What do I need in order to respond to reviewers?"
-	embedding, err := embed("", message)
+	if len(os.Args) > 1 {
+		message = strings.Join(os.Args[1:], " ")
+	}
+	image, text := rag.ParseQuery(message)
+	embedding, err := embed(image, text)
 	if err != nil {
 		log.Fatal(err)
 	}
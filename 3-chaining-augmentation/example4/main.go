@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -9,9 +10,13 @@ import (
 	"log"
 	"math"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
 	"github.com/predictionguard/go-client"
 )
 
@@ -19,26 +24,7 @@ import (
 var host = "https://api.predictionguard.com"
 var apiKey = os.Getenv("PGKEY")
 
-// qAPromptTemplate is a template for a question and answer prompt.
-func qAPromptTemplate(context, question string) string {
-	return fmt.Sprintf(`Context: "%s"
-
-Question: "%s"
-`, context, question)
-}
-
-// VectorizedChunk is a struct that holds a vectorized chunk.
-type VectorizedChunk struct {
-	Id       int       `json:"id"`
-	Chunk    string    `json:"chunk"`
-	Vector   []float64 `json:"vector"`
-	Metadata string    `json:"metadata"`
-}
-
-// VectorizedChunks is a slice of vectorized chunks.
-type VectorizedChunks []VectorizedChunk
-
-func embed(imageLink string, text string) (*VectorizedChunk, error) {
+func embed(imageLink string, text string) (*rag.VectorizedChunk, error) {
 
 	logger := func(ctx context.Context, msg string, v ...any) {
 		//s := fmt.Sprintf("msg: %s", msg)
@@ -50,21 +36,16 @@ func embed(imageLink string, text string) (*VectorizedChunk, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var image client.ImageNetwork
-	if imageLink != "" {
-		imageParsed, err := client.NewImageNetwork(imageLink)
-		if err != nil {
-			return nil, fmt.Errorf("ERROR: %w", err)
-		}
-		image = imageParsed
-	}
-
 	input := []client.EmbeddingInput{
 		{
 			Text: text,
 		},
 	}
 	if imageLink != "" {
+		image, err := rag.NewImage(imageLink)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: %w", err)
+		}
 		input[0].Image = image
 	}
 
@@ -73,7 +54,7 @@ func embed(imageLink string, text string) (*VectorizedChunk, error) {
 		return nil, fmt.Errorf("ERROR: %w", err)
 	}
 
-	return &VectorizedChunk{
+	return &rag.VectorizedChunk{
 		Chunk:  text,
 		Vector: resp.Data[0].Embedding,
 	}, nil
@@ -111,24 +92,35 @@ func cosineSimilarity(a []float64, b []float64) (cosine float64, err error) {
 	return sumA / (math.Sqrt(s1) * math.Sqrt(s2)), nil
 }
 
-// search through the vectorized chunks to find the most similar chunk.
-func search(chunks VectorizedChunks, embedding VectorizedChunk) (string, error) {
-	outChunk := ""
-	var maxSimilarity float64 = 0.0
+// search through the vectorized chunks to find the k chunks most similar
+// to the embedding, joined into a single context.
+func search(chunks rag.VectorizedChunks, embedding rag.VectorizedChunk, k int) (string, error) {
+	type match struct {
+		chunk      string
+		similarity float64
+	}
+	matches := []match{}
 	for _, c := range chunks {
-		distance, err := cosineSimilarity(c.Vector, embedding.Vector)
+		similarity, err := cosineSimilarity(c.Vector, embedding.Vector)
 		if err != nil {
 			return "", err
 		}
-		if distance > maxSimilarity {
-			outChunk = c.Chunk
-			maxSimilarity = distance
-		}
+		matches = append(matches, match{chunk: c.Chunk, similarity: similarity})
 	}
-	return outChunk, nil
+
+	// Sort the chunks from the most to the least similar.
+	slices.SortStableFunc(matches, func(a, b match) int {
+		return cmp.Compare(b.similarity, a.similarity)
+	})
+
+	outChunks := []string{}
+	for i := 0; i < k && i < len(matches); i++ {
+		outChunks = append(outChunks, matches[i].chunk)
+	}
+	return strings.Join(outChunks, "\n\n"), nil
 }
 
-func run(query, queryContext string) error {
+func run(spec llm.ModelSpec, query, queryContext string, shots []client.ChatInputMessage) (string, error) {
 
 	logger := func(ctx context.Context, msg string, v ...any) {
 		s := fmt.Sprintf("msg: %s", msg)
@@ -143,43 +135,64 @@ func run(query, queryContext string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	input := client.ChatSSEInput{
-		Model: client.Models.Hermes2ProLlama38B,
-		Messages: []client.ChatInputMessage{
-			{
-				Role:    client.Roles.System,
-				Content: "Read the context provided by the user and answer their question. If the question cannot be answered based on the context alone or the context does not explicitly say the answer to the question, respond \"Sorry I had trouble answering this question, based on the information I found\".",
-			},
-			{
-				Role: client.Roles.User,
-				Content: qAPromptTemplate(
-					string(queryContext),
-					query,
-				),
-			},
-		},
-		MaxTokens:   1000,
-		Temperature: 0.3,
+	// Render the prompts with the context and the question.
+	system, err := prompt.Configured().Render("system", nil)
+	if err != nil {
+		return "", fmt.Errorf("ERROR: %w", err)
+	}
+	qa, err := prompt.Configured().Render("qa", map[string]any{
+		"context":  string(queryContext),
+		"question": query,
+	})
+	if err != nil {
+		return "", fmt.Errorf("ERROR: %w", err)
 	}
 
-	ch := make(chan client.ChatSSE, 1000)
+	messages := []client.ChatInputMessage{
+		{
+			Role:    client.Roles.System,
+			Content: system,
+		},
+	}
+	messages = append(messages, shots...)
+	messages = append(messages, client.ChatInputMessage{
+		Role:    client.Roles.User,
+		Content: qa,
+	})
 
-	err := cln.ChatSSE(ctx, input, ch)
-	if err != nil {
-		return fmt.Errorf("ERROR: %w", err)
+	input := client.ChatSSEInput{
+		Model:       spec.Model,
+		Messages:    messages,
+		MaxTokens:   spec.Defaults.MaxTokens,
+		Temperature: spec.Defaults.Temperature,
 	}
 
-	for resp := range ch {
-		for _, choice := range resp.Choices {
-			fmt.Print(choice.Delta.Content)
+	// Stream the answer. A stream that breaks off fails, rather than
+	// passing part of an answer off as all of it.
+	var answer llm.Message
+	for event, err := range llm.Stream(ctx, cln, input) {
+		if err != nil {
+			return "", fmt.Errorf("ERROR: %w", err)
 		}
+		fmt.Print(event.Delta)
+		answer.Add(event)
 	}
 
-	return nil
+	return answer.Content, nil
 }
 
+// imageQuestion is asked about an image that is queried without text.
+const imageQuestion = "What does the documentation say about what is shown in this image?"
+
 func main() {
 
+	// Look up the chat model, the one named by $GENAI_MODEL or the default
+	// one, and the parameters to call it with.
+	spec, err := llm.DefaultRegistry.FromEnv(llm.OpChat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Open the JSON file and load in the vectorized embeddings.
 	f, err := os.Open("../example2/chunks.json")
 	if err != nil {
@@ -188,11 +201,42 @@ func main() {
 	defer f.Close()
 
 	// Load it into the chunks value.
-	var chunks VectorizedChunks
+	var chunks rag.VectorizedChunks
 	if err := json.NewDecoder(f).Decode(&chunks); err != nil {
 		log.Fatal(err)
 	}
 
+	// Load the worked examples and embed their inputs.
+	examples, err := rag.LoadExamples("examples.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i, example := range examples {
+		embedding, err := embed("", example.Input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		examples[i].Vector = embedding.Vector
+	}
+
+	// Detect the refusal the system prompt tells the model to answer with,
+	// and search the chunks again for the questions it refuses.
+	refusal, err := rag.NewRefusal(prompt.Configured())
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := func(ctx context.Context, msg string, v ...any) {
+		//s := fmt.Sprintf("msg: %s", msg)
+		//log.Println(s)
+	}
+	retriever := rag.RAG{
+		Client:  llm.NewClient(logger, host, apiKey),
+		Chunks:  chunks,
+		Model:   spec,
+		TopK:    1,
+		Prompts: prompt.Configured(),
+	}
+
 	// Start a cycle of listening for questions and responding to the questions.
 	fmt.Println("")
 	scanner := bufio.NewScanner(os.Stdin)
@@ -208,23 +252,67 @@ func main() {
 			break
 		}
 
-		// Embed a question for the RAG answer.
-		embedding, err := embed("", input)
+		// Embed a question for the RAG answer, together with any image
+		// it refers to.
+		image, question := rag.ParseQuery(input)
+		embedding, err := embed(image, question)
 		if err != nil {
 			log.Fatal(err)
 		}
 
 		// Search for the relevant chunk.
-		chunk, err := search(chunks, *embedding)
+		chunk, err := search(chunks, *embedding, 1)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Pick the worked examples most similar to the question, each
+		// asked about a context of its own like the question is.
+		selected, err := examples.Select(embedding.Vector, 2, 500)
 		if err != nil {
 			log.Fatal(err)
 		}
+		shots, err := rag.ExampleMessages(selected, prompt.Configured(), "qa")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// The model only reads text, so ask about an image on its own.
+		if question == "" {
+			question = imageQuestion
+		}
 
 		// Print the bot response.
 		fmt.Print("\n🤖: ")
-		if err := run(input, string(chunk)); err != nil {
+		full_message, err := run(spec, question, string(chunk), shots)
+		if err != nil {
 			log.Fatalln(err)
 		}
+
+		// The model refuses when the chunk does not answer the question.
+		// Retry with the escalations of the genai module in turn: more
+		// chunks, a query rewritten for the search and a hybrid search,
+		// before giving up.
+		ctx := context.Background()
+		for _, escalate := range rag.DefaultEscalations {
+			refused, err := refusal.Detect(ctx, full_message)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !refused {
+				break
+			}
+
+			attempt, results, err := escalate(ctx, retriever, input)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("\n\n(refused, retrying with %s of %q, %d chunks)\n\n🤖: ", attempt.Step, attempt.Query, attempt.Chunks)
+			full_message, err = run(spec, question, rag.Context(results), shots)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
 		fmt.Print("\n\n")
 	}
 }
@@ -1,18 +1,21 @@
 module github.com/dwhitena/go-genai-webinar/3-chaining-augmentation/example5
 
-go 1.22.3
+go 1.23
 
 require (
-	github.com/JohannesKaufmann/html-to-markdown v1.5.0
+	github.com/JohannesKaufmann/html-to-markdown v1.6.0
 	github.com/cohere-ai/cohere-go v0.2.0
+	github.com/dwhitena/go-genai-webinar/genai v0.0.0
 	github.com/predictionguard/go-client v0.13.0
 )
 
 require (
-	github.com/PuerkitoBio/goquery v1.8.1 // indirect
-	github.com/andybalholm/cascadia v1.3.1 // indirect
+	github.com/PuerkitoBio/goquery v1.9.2 // indirect
+	github.com/andybalholm/cascadia v1.3.2 // indirect
 	github.com/cohere-ai/tokenizer v1.1.1 // indirect
 	github.com/dlclark/regexp2 v1.4.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	golang.org/x/net v0.19.0 // indirect
+	golang.org/x/net v0.25.0 // indirect
 )
+
+replace github.com/dwhitena/go-genai-webinar/genai => ../../genai
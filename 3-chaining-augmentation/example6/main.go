@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -10,10 +11,16 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
 	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/dwhitena/go-genai-webinar/genai/chain"
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/memory"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
 	"github.com/predictionguard/go-client"
 )
 
@@ -21,26 +28,7 @@ import (
 var host = "https://api.predictionguard.com"
 var apiKey = os.Getenv("PGKEY")
 
-// qAPromptTemplate is a template for a question and answer prompt.
-func qAPromptTemplate(context, question string) string {
-	return fmt.Sprintf(`Context: "%s"
-
-Question: "%s"
-`, context, question)
-}
-
-// VectorizedChunk is a struct that holds a vectorized chunk.
-type VectorizedChunk struct {
-	Id       int       `json:"id"`
-	Chunk    string    `json:"chunk"`
-	Vector   []float64 `json:"vector"`
-	Metadata string    `json:"metadata"`
-}
-
-// VectorizedChunks is a slice of vectorized chunks.
-type VectorizedChunks []VectorizedChunk
-
-func embed(imageLink string, text string) (*VectorizedChunk, error) {
+func embed(ctx context.Context, imageLink string, text string) (*rag.VectorizedChunk, error) {
 
 	logger := func(ctx context.Context, msg string, v ...any) {
 		//s := fmt.Sprintf("msg: %s", msg)
@@ -49,24 +37,19 @@ func embed(imageLink string, text string) (*VectorizedChunk, error) {
 
 	cln := client.New(logger, host, apiKey)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	var image client.ImageNetwork
-	if imageLink != "" {
-		imageParsed, err := client.NewImageNetwork(imageLink)
-		if err != nil {
-			return nil, fmt.Errorf("ERROR: %w", err)
-		}
-		image = imageParsed
-	}
-
 	input := []client.EmbeddingInput{
 		{
 			Text: text,
 		},
 	}
 	if imageLink != "" {
+		image, err := rag.NewImage(imageLink)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: %w", err)
+		}
 		input[0].Image = image
 	}
 
@@ -75,7 +58,7 @@ func embed(imageLink string, text string) (*VectorizedChunk, error) {
 		return nil, fmt.Errorf("ERROR: %w", err)
 	}
 
-	return &VectorizedChunk{
+	return &rag.VectorizedChunk{
 		Chunk:  text,
 		Vector: resp.Data[0].Embedding,
 	}, nil
@@ -113,24 +96,70 @@ func cosineSimilarity(a []float64, b []float64) (cosine float64, err error) {
 	return sumA / (math.Sqrt(s1) * math.Sqrt(s2)), nil
 }
 
-// search through the vectorized chunks to find the most similar chunk.
-func search(chunks VectorizedChunks, embedding VectorizedChunk) (string, error) {
-	outChunk := ""
-	var maxSimilarity float64 = 0.0
+// search through the vectorized chunks to find the k chunks most similar
+// to the embedding, joined into a single context.
+func search(chunks rag.VectorizedChunks, embedding rag.VectorizedChunk, k int) (string, error) {
+	type match struct {
+		chunk      string
+		similarity float64
+	}
+	matches := []match{}
 	for _, c := range chunks {
-		distance, err := cosineSimilarity(c.Vector, embedding.Vector)
+		similarity, err := cosineSimilarity(c.Vector, embedding.Vector)
 		if err != nil {
 			return "", err
 		}
-		if distance > maxSimilarity {
-			outChunk = c.Chunk
-			maxSimilarity = distance
-		}
+		matches = append(matches, match{chunk: c.Chunk, similarity: similarity})
+	}
+
+	// Sort the chunks from the most to the least similar.
+	slices.SortStableFunc(matches, func(a, b match) int {
+		return cmp.Compare(b.similarity, a.similarity)
+	})
+
+	outChunks := []string{}
+	for i := 0; i < k && i < len(matches); i++ {
+		outChunks = append(outChunks, matches[i].chunk)
+	}
+	return strings.Join(outChunks, "\n\n"), nil
+}
+
+// turnMessages assembles the messages of a turn of the conversation: the
+// system prompt, the earlier turns as they were asked and answered, and
+// the question of this turn with the context retrieved for it. The
+// context belongs to the current turn only, so it never replaces an
+// earlier answer and does not pile up in the history.
+func turnMessages(history []client.ChatInputMessage, query, queryContext string) ([]client.ChatInputMessage, error) {
+
+	// Render the prompts with the context and the question.
+	system, err := prompt.Configured().Render("system", nil)
+	if err != nil {
+		return nil, err
+	}
+	qa, err := prompt.Configured().Render("qa", map[string]any{
+		"context":  queryContext,
+		"question": query,
+	})
+	if err != nil {
+		return nil, err
 	}
-	return outChunk, nil
+
+	messages := make([]client.ChatInputMessage, 0, len(history)+2)
+	messages = append(messages, client.ChatInputMessage{
+		Role:    client.Roles.System,
+		Content: system,
+	})
+	messages = append(messages, history...)
+	messages = append(messages, client.ChatInputMessage{
+		Role:    client.Roles.User,
+		Content: qa,
+	})
+	return messages, nil
 }
 
-func run(query, queryContext string, messages []client.ChatInputMessage) (float64, string, error) {
+// generate streams the answer to a query from the context, after the
+// history of the conversation, and returns the full answer.
+func generate(ctx context.Context, spec llm.ModelSpec, query, queryContext string, history []client.ChatInputMessage) (string, error) {
 
 	logger := func(ctx context.Context, msg string, v ...any) {
 		s := fmt.Sprintf("msg: %s", msg)
@@ -142,51 +171,57 @@ func run(query, queryContext string, messages []client.ChatInputMessage) (float6
 
 	cln := client.New(logger, host, apiKey)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	inputMod := client.ChatInputMessage{
-		Role: client.Roles.User,
-		Content: qAPromptTemplate(
-			string(queryContext),
-			query,
-		),
-	}
-	if len(messages) > 1 {
-		messages[len(messages)-1] = inputMod
-	} else {
-		messages = append(messages, inputMod)
+	messages, err := turnMessages(history, query, queryContext)
+	if err != nil {
+		return "", fmt.Errorf("ERROR: %w", err)
 	}
 
 	input := client.ChatSSEInput{
-		Model:       client.Models.Hermes2ProLlama38B,
+		Model:       spec.Model,
 		Messages:    messages,
-		MaxTokens:   1000,
-		Temperature: 0.3,
+		MaxTokens:   spec.Defaults.MaxTokens,
+		Temperature: spec.Defaults.Temperature,
 	}
 
-	ch := make(chan client.ChatSSE, 1000)
-
-	err := cln.ChatSSE(ctx, input, ch)
-	if err != nil {
-		return 0.0, "", fmt.Errorf("ERROR: %w", err)
+	// Stream the answer. A stream that breaks off fails, rather than
+	// passing part of an answer off as all of it.
+	var answer llm.Message
+	for event, err := range llm.Stream(ctx, cln, input) {
+		if err != nil {
+			return "", fmt.Errorf("ERROR: %w", err)
+		}
+		fmt.Print(event.Delta)
+		answer.Add(event)
 	}
 
-	full_message := ""
-	for resp := range ch {
-		for _, choice := range resp.Choices {
-			fmt.Print(choice.Delta.Content)
-			full_message = full_message + choice.Delta.Content
-		}
+	return answer.Content, nil
+}
+
+// factuality checks the factuality of an answer against its context.
+func factuality(ctx context.Context, queryContext, answer string) (float64, error) {
+
+	logger := func(ctx context.Context, msg string, v ...any) {
+		//s := fmt.Sprintf("msg: %s", msg)
+		//log.Println(s)
 	}
 
-	// Check factuality.
-	resp, err := cln.Factuality(ctx, queryContext, full_message)
+	cln := client.New(logger, host, apiKey)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err := cln.Factuality(ctx, queryContext, answer)
 	if err != nil {
-		return 0.0, "", fmt.Errorf("ERROR: %w", err)
+		return 0.0, fmt.Errorf("ERROR: %w", err)
+	}
+	if len(resp.Checks) == 0 {
+		return 0.0, errors.New("ERROR: no checks in response")
 	}
 
-	return resp.Checks[0].Score, full_message, nil
+	return resp.Checks[0].Score, nil
 }
 
 // characterTextSplitter takes in a string and splits the string into
@@ -250,11 +285,154 @@ func websiteChunks(website string, start string, end string) ([]string, error) {
 	return chunks, nil
 }
 
+// historyBudget is the number of tokens of the latest turns of the
+// conversation sent with a question. Older turns are summarized.
+const historyBudget = 2000
+
+// imageQuestion is asked about an image that is queried without text.
+const imageQuestion = "What does the documentation say about what is shown in this image?"
+
+// turn is a question on its way through the chain that answers it: the
+// input as typed, the image and the question asked, the query searched
+// for, the context found, and the answer, whether it is a refusal, and
+// its factuality.
+type turn struct {
+	input     string
+	image     string
+	question  string
+	query     string
+	history   []client.ChatInputMessage
+	embedding *rag.VectorizedChunk
+	context   string
+	answer    string
+	refused   bool
+	score     float64
+}
+
+// newChain builds the chain that answers a question from the chunks: it
+// embeds the query, searches for the relevant chunk and answers from it
+// with the model of spec, then retries a refused question with the
+// escalations of the genai module, more chunks, a query rewritten for the
+// search and a hybrid search, before checking the factuality of the
+// answer.
+func newChain(spec llm.ModelSpec, chunks rag.VectorizedChunks, refusal *rag.Refusal) chain.Step[turn, turn] {
+	logger := func(ctx context.Context, msg string, v ...any) {
+		//s := fmt.Sprintf("msg: %s", msg)
+		//log.Println(s)
+	}
+	retriever := rag.RAG{
+		Client:  llm.NewClient(logger, host, apiKey),
+		Chunks:  chunks,
+		Model:   spec,
+		TopK:    1,
+		Prompts: prompt.Configured(),
+	}
+
+	embedStep := func(ctx context.Context, t turn) (turn, error) {
+		embedding, err := embed(ctx, t.image, t.query)
+		if err != nil {
+			return t, err
+		}
+		t.embedding = embedding
+		return t, nil
+	}
+
+	searchStep := func(ctx context.Context, t turn) (turn, error) {
+		chunk, err := search(chunks, *t.embedding, 1)
+		if err != nil {
+			return t, err
+		}
+		t.context = chunk
+		return t, nil
+	}
+
+	answerStep := func(ctx context.Context, t turn) (turn, error) {
+		answer, err := generate(ctx, spec, t.question, t.context, t.history)
+		if err != nil {
+			return t, err
+		}
+		t.answer = answer
+		return t, nil
+	}
+
+	factualityStep := func(ctx context.Context, t turn) (turn, error) {
+		score, err := factuality(ctx, t.context, t.answer)
+		if err != nil {
+			return t, err
+		}
+		t.score = score
+		return t, nil
+	}
+
+	// escalateStep retrieves the context of another attempt at a refused
+	// question.
+	escalateStep := func(escalate rag.Escalation) chain.Step[turn, turn] {
+		return func(ctx context.Context, t turn) (turn, error) {
+			attempt, results, err := escalate(ctx, retriever, t.input)
+			if err != nil {
+				return t, err
+			}
+			t.query = attempt.Query
+			t.context = rag.Context(results)
+			fmt.Printf("\n\n(refused, retrying with %s of %q, %d chunks)\n\n🤖: ", attempt.Step, attempt.Query, attempt.Chunks)
+			return t, nil
+		}
+	}
+
+	retrieve := chain.Seq(
+		chain.Named("embed", embedStep),
+		chain.Named("search", searchStep),
+	)
+
+	// The model refuses when the chunks do not answer the question.
+	detectStep := func(ctx context.Context, t turn) (turn, error) {
+		refused, err := refusal.Detect(ctx, t.answer)
+		if err != nil {
+			return t, err
+		}
+		t.refused = refused
+		return t, nil
+	}
+
+	answer := chain.Seq(
+		chain.Named("answer", answerStep),
+		chain.Named("detect", detectStep),
+	)
+	refused := func(ctx context.Context, t turn) bool {
+		return t.refused
+	}
+
+	return chain.Seq(
+		retrieve,
+		answer,
+		chain.Branch(refused,
+			chain.Named("widen", chain.Seq(escalateStep(rag.Widen(3)), answer)),
+			chain.Pass[turn](),
+		),
+		chain.Branch(refused,
+			chain.Named("rewrite", chain.Seq(escalateStep(rag.Rewrite()), answer)),
+			chain.Pass[turn](),
+		),
+		chain.Branch(refused,
+			chain.Named("hybrid", chain.Seq(escalateStep(rag.Hybrid()), answer)),
+			chain.Pass[turn](),
+		),
+		chain.Named("factuality", factualityStep),
+	)
+}
+
 func main() {
 
 	// Get the website from the command line arg.
 	website := os.Args[1]
 
+	// Look up the chat model, the one named by $GENAI_MODEL or the default
+	// one, and the parameters to call it with.
+	spec, err := llm.DefaultRegistry.FromEnv(llm.OpChat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Download the Go contribution guide in chunks.
 	chunks, err := websiteChunks(website, "", "")
 	if err != nil {
@@ -262,10 +440,10 @@ func main() {
 	}
 
 	// Embed the website chunks.
-	vectorizedChunks := VectorizedChunks{}
+	vectorizedChunks := rag.VectorizedChunks{}
 	for i, chunk := range chunks {
 		fmt.Printf("Embedding chunk %d of %d\n", i+1, len(chunks))
-		vectorizedChunk, err := embed("", chunk)
+		vectorizedChunk, err := embed(context.Background(), "", chunk)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -274,15 +452,32 @@ func main() {
 		vectorizedChunks[i].Metadata = chunk
 	}
 
+	// Detect the refusal the system prompt tells the model to answer with,
+	// to retry the questions the model refuses, and build the chain that
+	// answers a question.
+	refusal, err := rag.NewRefusal(prompt.Configured())
+	if err != nil {
+		log.Fatal(err)
+	}
+	answerChain := newChain(spec, vectorizedChunks, refusal)
+
+	// Remember the latest turns of the conversation that fit a budget of
+	// tokens and a summary of the turns before them, so the conversation
+	// never outgrows the context window of the model.
+	logger := func(ctx context.Context, msg string, v ...any) {
+		//s := fmt.Sprintf("msg: %s", msg)
+		//log.Println(s)
+	}
+	mem := &memory.Summary{
+		Client:  client.New(logger, host, apiKey),
+		Model:   spec,
+		Prompts: prompt.Configured(),
+		Budget:  historyBudget,
+	}
+
 	// Start a cycle of listening for questions and responding to the questions.
 	fmt.Println("")
 	scanner := bufio.NewScanner(os.Stdin)
-	messages := []client.ChatInputMessage{
-		{
-			Role:    client.Roles.System,
-			Content: "Read the context provided by the user and answer their question. If the question cannot be answered based on the context alone or the context does not explicitly say the answer to the question, respond \"Sorry I had trouble answering this question, based on the information I found\".",
-		},
-	}
 	for {
 		fmt.Print("🧑: ")
 		if !scanner.Scan() {
@@ -295,35 +490,35 @@ func main() {
 			break
 		}
 
-		// Embed a question for the RAG answer.
-		embedding, err := embed("", input)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// Search for the relevant chunk.
-		chunk, err := search(vectorizedChunks, *embedding)
-		if err != nil {
-			log.Fatal(err)
+		// Answer the question, searching with its text and any image it
+		// refers to. The model only reads text, so ask about an image on
+		// its own. The history of the conversation is what is remembered
+		// of the earlier turns.
+		image, question := rag.ParseQuery(input)
+		t := turn{
+			input:    input,
+			image:    image,
+			question: cmp.Or(question, imageQuestion),
+			query:    question,
+			history:  mem.Messages(),
 		}
 
 		// Print the bot response.
 		fmt.Print("\n🤖: ")
-		score, full_message, err := run(input, string(chunk), messages)
+		t, err = answerChain(context.Background(), t)
 		if err != nil {
 			log.Fatalln(err)
 		}
-		fmt.Print("\n\nFactuality Score: ", score)
+		fmt.Print("\n\nFactuality Score: ", t.score)
 		fmt.Print("\n\n")
 
-		// Add the message to the messages.
-		messages = append(messages, client.ChatInputMessage{
-			Role:    client.Roles.User,
-			Content: input,
-		})
-		messages = append(messages, client.ChatInputMessage{
-			Role:    client.Roles.Assistant,
-			Content: full_message,
-		})
+		// Remember the question and the answer as they were asked and
+		// answered, without the context retrieved for them.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = mem.Add(ctx, memory.Turn{Question: t.question, Answer: t.answer})
+		cancel()
+		if err != nil {
+			log.Fatalln(err)
+		}
 	}
 }
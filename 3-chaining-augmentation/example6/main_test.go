@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/memory"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/predictionguard/go-client"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// turns are the turns of the conversation: the question, the context
+// retrieved for it and the answer.
+var turns = []struct {
+	question string
+	context  string
+	answer   string
+}{
+	{
+		question: "What is a goroutine?",
+		context:  "A goroutine is a lightweight thread managed by the Go runtime.",
+		answer:   "A function that runs concurrently with the others.",
+	},
+	{
+		question: "How do they communicate?",
+		context:  "Channels are the pipes that connect concurrent goroutines.",
+		answer:   "Goroutines communicate through channels.",
+	},
+	{
+		question: "Can a channel be closed twice?",
+		context:  "Closing a closed channel causes a run-time panic.",
+		answer:   "No, closing a closed channel panics.",
+	},
+}
+
+// summarizer is a client that answers every chat with the summary, the
+// only call a memory makes.
+type summarizer struct {
+	llm.Client
+	summary string
+	inputs  []client.ChatInput
+}
+
+// Chat implements the llm.Client interface.
+func (s *summarizer) Chat(ctx context.Context, input client.ChatInput) (client.Chat, error) {
+	s.inputs = append(s.inputs, input)
+	return client.Chat{Choices: []client.ChatChoice{{Message: client.ChatMessage{Content: s.summary}}}}, nil
+}
+
+// dump writes the messages of a turn as they are sent to the model.
+func dump(messages []client.ChatInputMessage) string {
+	var b strings.Builder
+	for _, m := range messages {
+		fmt.Fprintf(&b, "[%s]\n%s\n\n", m.Role, m.Content)
+	}
+	return b.String()
+}
+
+func TestTurnMessages(t *testing.T) {
+
+	// The budget keeps a single turn as it is, so the first turn is folded
+	// into the summary by the third.
+	fake := &summarizer{summary: "The user asked what a goroutine is: a function that runs concurrently with the others."}
+	mem := &memory.Summary{
+		Client:  fake,
+		Model:   llm.DefaultRegistry[llm.DefaultModel],
+		Prompts: prompt.Configured(),
+		Budget:  40,
+	}
+
+	for i, turn := range turns {
+		t.Run(fmt.Sprintf("turn-%d", i+1), func(t *testing.T) {
+			messages, err := turnMessages(mem.Messages(), turn.question, turn.context)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The context of a turn is only sent with its question.
+			last := len(messages) - 1
+			for j, earlier := range turns[:i+1] {
+				for k, m := range messages {
+					if got, want := strings.Contains(m.Content, earlier.context), j == i && k == last; got != want {
+						t.Errorf("context of turn %d in message %d: %t, want %t", j+1, k, got, want)
+					}
+				}
+			}
+
+			got := dump(messages)
+			golden := filepath.Join("testdata", fmt.Sprintf("turn-%d.golden", i+1))
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("got messages\n%s\nwant\n%s", got, want)
+			}
+		})
+
+		if err := mem.Add(context.Background(), memory.Turn{Question: turn.question, Answer: turn.answer}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first and second turns are folded in as the second and third
+	// are added.
+	if len(fake.inputs) != 2 {
+		t.Errorf("got %d summaries written, want 2", len(fake.inputs))
+	}
+
+	// Nor is a context ever folded into the summary.
+	for _, input := range fake.inputs {
+		for j, turn := range turns {
+			if strings.Contains(input.Messages[0].Content, turn.context) {
+				t.Errorf("context of turn %d in the summary prompt", j+1)
+			}
+		}
+	}
+}
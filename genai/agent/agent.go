@@ -0,0 +1,191 @@
+// Package agent runs a model in a loop in which it picks tools, Go
+// functions with JSON arguments, to answer a question. At every step the
+// model reasons about what to do next and either calls a tool, whose
+// result is fed back to it, or gives its final answer (the ReAct pattern).
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dwhitena/go-genai-webinar/genai/chain"
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/predictionguard/go-client"
+)
+
+// ErrMaxSteps is returned when the model has not answered within the
+// step limit.
+var ErrMaxSteps = errors.New("no answer within the step limit")
+
+// Tool is a function the model can call. Its arguments are a JSON object
+// described by the Schema.
+type Tool struct {
+	Name        string
+	Description string
+	Schema      *llm.Schema
+	Call        func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+// NewTool constructs a tool from a function of typed arguments, described
+// to the model by the JSON Schema of A. Arguments that do not match the
+// schema are rejected before the function is called.
+func NewTool[A any](name string, description string, fn func(ctx context.Context, args A) (string, error)) Tool {
+	schema := llm.SchemaOf[A]()
+
+	call := func(ctx context.Context, data json.RawMessage) (string, error) {
+		var raw any
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return "", fmt.Errorf("arguments are not JSON: %w", err)
+		}
+		if errs := schema.Validate(raw); len(errs) > 0 {
+			return "", fmt.Errorf("invalid arguments: %s", strings.Join(errs, "; "))
+		}
+
+		var args A
+		if err := json.Unmarshal(data, &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+
+		return fn(ctx, args)
+	}
+
+	return Tool{Name: name, Description: description, Schema: schema, Call: call}
+}
+
+// finish is the action the model takes to answer.
+const finish = "finish"
+
+// decision is what the model decides to do at a step.
+type decision struct {
+	Thought string          `json:"thought" description:"your reasoning about what to do next"`
+	Action  string          `json:"action" description:"the name of the tool to call, or finish to give the final answer"`
+	Input   json.RawMessage `json:"input,omitempty" description:"the arguments of the tool, as described by its schema"`
+	Answer  string          `json:"answer,omitempty" description:"the final answer, when the action is finish"`
+}
+
+// Step is a step of a run: the thought of the model, the tool it called
+// with its input, and what the tool returned.
+type Step struct {
+	Thought     string
+	Tool        string
+	Input       string
+	Observation string
+}
+
+// Result is the answer of a run and the steps that led to it.
+type Result struct {
+	Answer string
+	Steps  []Step
+}
+
+// Agent answers questions with a chat model that calls tools, for up to
+// MaxSteps steps. Each step is written to Log, if any. Repairs is the
+// number of times a step that is not valid JSON is sent back to the
+// model.
+type Agent struct {
+	Client   llm.Client
+	Model    llm.ModelSpec
+	Tools    []Tool
+	MaxSteps int
+	Repairs  int
+	Log      io.Writer
+}
+
+// Run answers a question. When the step limit is reached, the steps taken
+// are returned with ErrMaxSteps.
+func (a Agent) Run(ctx context.Context, question string) (Result, error) {
+	tools := map[string]Tool{}
+	for _, tool := range a.Tools {
+		tools[tool.Name] = tool
+	}
+
+	messages := []client.ChatInputMessage{
+		{Role: client.Roles.System, Content: a.systemPrompt()},
+		{Role: client.Roles.User, Content: question},
+	}
+
+	var result Result
+	for step := 1; step <= max(a.MaxSteps, 1); step++ {
+		d, err := llm.Generate[decision](ctx, a.Client, a.Model, messages, a.Repairs)
+		if err != nil {
+			return result, fmt.Errorf("step %d: %w", step, err)
+		}
+		a.logf("Thought: %s\n", d.Thought)
+
+		if d.Action == finish {
+			a.logf("Answer: %s\n", d.Answer)
+			result.Answer = d.Answer
+			return result, nil
+		}
+
+		observation := a.call(ctx, tools, d)
+		a.logf("Action: %s %s\nObservation: %s\n\n", d.Action, d.Input, observation)
+
+		result.Steps = append(result.Steps, Step{
+			Thought:     d.Thought,
+			Tool:        d.Action,
+			Input:       string(d.Input),
+			Observation: observation,
+		})
+
+		// Feed the decision and what came of it back to the model.
+		data, err := json.Marshal(d)
+		if err != nil {
+			return result, err
+		}
+		messages = append(messages,
+			client.ChatInputMessage{Role: client.Roles.Assistant, Content: string(data)},
+			client.ChatInputMessage{Role: client.Roles.User, Content: "Observation: " + observation},
+		)
+	}
+
+	return result, ErrMaxSteps
+}
+
+// call calls the tool the model picked. Errors are observations too, so
+// the model can correct itself.
+func (a Agent) call(ctx context.Context, tools map[string]Tool, d decision) string {
+	tool, exists := tools[d.Action]
+	if !exists {
+		names := make([]string, 0, len(a.Tools))
+		for _, t := range a.Tools {
+			names = append(names, t.Name)
+		}
+		return fmt.Sprintf("Error: unknown tool %q, use one of %s or %s", d.Action, strings.Join(names, ", "), finish)
+	}
+
+	input := d.Input
+	if len(input) == 0 {
+		input = json.RawMessage("{}")
+	}
+
+	// Tools are named steps, so the hooks of the context see every call.
+	observation, err := chain.Named(tool.Name, tool.Call)(ctx, input)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	return observation
+}
+
+// systemPrompt describes the protocol and the tools to the model.
+func (a Agent) systemPrompt() string {
+	var b strings.Builder
+	b.WriteString("Answer the question of the user. You can use the following tools:\n\n")
+	for _, tool := range a.Tools {
+		schema, _ := json.Marshal(tool.Schema)
+		fmt.Fprintf(&b, "- %s: %s Arguments: %s\n", tool.Name, tool.Description, schema)
+	}
+	fmt.Fprintf(&b, "\nWork step by step. At each step, think about what to do next, then either call one tool and wait for its observation, or use the action %q to give your final answer once you know it.", finish)
+	return b.String()
+}
+
+// logf writes a step when a writer is provided.
+func (a Agent) logf(format string, v ...any) {
+	if a.Log != nil {
+		fmt.Fprintf(a.Log, format, v...)
+	}
+}
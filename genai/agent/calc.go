@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"math"
+)
+
+// functions are the functions of the calculator by name and number of
+// arguments.
+var functions = map[string]struct {
+	args int
+	fn   func(v ...float64) float64
+}{
+	"abs":   {1, func(v ...float64) float64 { return math.Abs(v[0]) }},
+	"ceil":  {1, func(v ...float64) float64 { return math.Ceil(v[0]) }},
+	"floor": {1, func(v ...float64) float64 { return math.Floor(v[0]) }},
+	"round": {1, func(v ...float64) float64 { return math.Round(v[0]) }},
+	"sqrt":  {1, func(v ...float64) float64 { return math.Sqrt(v[0]) }},
+	"exp":   {1, func(v ...float64) float64 { return math.Exp(v[0]) }},
+	"log":   {1, func(v ...float64) float64 { return math.Log(v[0]) }},
+	"pow":   {2, func(v ...float64) float64 { return math.Pow(v[0], v[1]) }},
+	"min":   {2, func(v ...float64) float64 { return math.Min(v[0], v[1]) }},
+	"max":   {2, func(v ...float64) float64 { return math.Max(v[0], v[1]) }},
+}
+
+// Calculate evaluates an arithmetic expression in floating point. The
+// expression is parsed with the Go parser, so it follows the precedence
+// of Go.
+func Calculate(expression string) (float64, error) {
+	expr, err := parser.ParseExpr(expression)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %q: %w", expression, err)
+	}
+	return eval(expr)
+}
+
+func eval(expr ast.Expr) (float64, error) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.INT && e.Kind != token.FLOAT {
+			return 0, fmt.Errorf("unexpected %s", e.Value)
+		}
+		// Literals are Go literals, like 0x1F, 1_000 or the octal 010.
+		v := constant.MakeFromLiteral(e.Value, e.Kind, 0)
+		if v.Kind() == constant.Unknown {
+			return 0, fmt.Errorf("invalid number %s", e.Value)
+		}
+		f, _ := constant.Float64Val(v)
+		return f, nil
+
+	case *ast.ParenExpr:
+		return eval(e.X)
+
+	case *ast.UnaryExpr:
+		x, err := eval(e.X)
+		if err != nil {
+			return 0, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return x, nil
+		case token.SUB:
+			return -x, nil
+		}
+		return 0, fmt.Errorf("unexpected operator %s", e.Op)
+
+	case *ast.BinaryExpr:
+		x, err := eval(e.X)
+		if err != nil {
+			return 0, err
+		}
+		y, err := eval(e.Y)
+		if err != nil {
+			return 0, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return x + y, nil
+		case token.SUB:
+			return x - y, nil
+		case token.MUL:
+			return x * y, nil
+		case token.QUO:
+			if y == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return x / y, nil
+		case token.REM:
+			if y == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return math.Mod(x, y), nil
+		}
+		return 0, fmt.Errorf("unexpected operator %s", e.Op)
+
+	case *ast.CallExpr:
+		name, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			return 0, errors.New("unexpected call")
+		}
+		f, exists := functions[name.Name]
+		if !exists {
+			return 0, fmt.Errorf("unknown function %s", name.Name)
+		}
+		if len(e.Args) != f.args {
+			return 0, fmt.Errorf("%s takes %d argument(s)", name.Name, f.args)
+		}
+		args := make([]float64, len(e.Args))
+		for i, arg := range e.Args {
+			v, err := eval(arg)
+			if err != nil {
+				return 0, err
+			}
+			args[i] = v
+		}
+		return f.fn(args...), nil
+
+	case *ast.Ident:
+		switch e.Name {
+		case "pi":
+			return math.Pi, nil
+		case "e":
+			return math.E, nil
+		}
+		return 0, fmt.Errorf("unknown name %s", e.Name)
+	}
+
+	return 0, errors.New("unexpected expression")
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       float64
+		wantErr    bool
+	}{
+		{expression: "1 + 2*3", want: 7},
+		{expression: "(1 + 2) * 3", want: 9},
+		{expression: "10 - 4 - 3", want: 3},
+		{expression: "-2 * -3", want: 6},
+		{expression: "+5 - -5", want: 10},
+		{expression: "7 / 2", want: 3.5},
+		{expression: "7 % 4", want: 3},
+		{expression: "pow(2, 10)", want: 1024},
+		{expression: "sqrt(16) + abs(-1)", want: 5},
+		{expression: "2 * pi", want: 2 * math.Pi},
+		{expression: "0x1F", want: 31},
+		{expression: "1_000", want: 1000},
+		{expression: "010", want: 8},
+		{expression: "0o17", want: 15},
+		{expression: "0b101", want: 5},
+		{expression: "1e3", want: 1000},
+		{expression: "1.5e-1", want: 0.15},
+		{expression: "1 / 0", wantErr: true},
+		{expression: "1 % 0", wantErr: true},
+		{expression: "'a'", wantErr: true},
+		{expression: `"1"`, wantErr: true},
+		{expression: "x + 1", wantErr: true},
+		{expression: "sqrt(1, 2)", wantErr: true},
+		{expression: "1 +", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			got, err := Calculate(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
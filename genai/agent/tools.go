@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/dwhitena/go-genai-webinar/genai/chain"
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
+)
+
+// maxFetch is the number of characters of a page the fetch tool returns,
+// so a page fits in the context of the model.
+const maxFetch = 4000
+
+// SearchTool searches the index of a RAG pipeline for the chunks most
+// similar to a query.
+func SearchTool(r rag.RAG) Tool {
+	type args struct {
+		Query string `json:"query" description:"what to search for"`
+	}
+
+	return NewTool("search", "Searches the documentation for the passages most relevant to a query.", func(ctx context.Context, a args) (string, error) {
+		results, err := r.Search(ctx, a.Query)
+		if err != nil {
+			return "", err
+		}
+
+		var b strings.Builder
+		for i, result := range results {
+			fmt.Fprintf(&b, "[%d] %s\n%s\n\n", i+1, result.Chunk.Source, result.Chunk.Chunk)
+		}
+		return b.String(), nil
+	})
+}
+
+// AskTool answers a question from the index of a RAG pipeline with its
+// retrieval chain, so the model can hand a question over whole instead of
+// reading the chunks itself.
+func AskTool(r rag.RAG) Tool {
+	type args struct {
+		Question string `json:"question" description:"a question about the documentation"`
+	}
+
+	answer := func(ctx context.Context, resp rag.Response) (string, error) {
+		return resp.Answer, nil
+	}
+	ask := chain.Then(r.Chain(io.Discard), answer)
+
+	return NewTool("ask", "Answers a question from the documentation.", func(ctx context.Context, a args) (string, error) {
+		return ask(ctx, rag.Retrieval{Query: a.Question})
+	})
+}
+
+// FetchTool downloads a web page and returns it as markdown, within the
+// timeout. The model picks the URL, so pages are only fetched from public
+// addresses: the loopback, link-local and private networks of the machine
+// it runs on are refused, also after a redirect.
+func FetchTool(timeout time.Duration) Tool {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: publicOnly,
+	}
+	cln := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+	return fetchTool(cln)
+}
+
+// publicOnly is the Control of a dialer that refuses to connect to
+// addresses that are not public, checked once the host is resolved.
+func publicOnly(network string, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return fmt.Errorf("%s is not a public address", addr)
+	}
+	return nil
+}
+
+// fetchTool downloads web pages with a client.
+func fetchTool(cln *http.Client) Tool {
+	type args struct {
+		URL string `json:"url" description:"the http or https URL of the page"`
+	}
+
+	return NewTool("fetch", "Downloads a web page and returns its text as markdown.", func(ctx context.Context, a args) (string, error) {
+		u, err := url.Parse(a.URL)
+		if err != nil {
+			return "", err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return "", errors.New("only http and https URLs can be fetched")
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := cln.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("status %s", resp.Status)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 5<<20))
+		if err != nil {
+			return "", err
+		}
+
+		converter := md.NewConverter(u.Hostname(), true, nil)
+		markdown, err := converter.ConvertString(string(body))
+		if err != nil {
+			return "", err
+		}
+		if len(markdown) > maxFetch {
+			markdown = strings.ToValidUTF8(markdown[:maxFetch], "") + "\n\n[truncated]"
+		}
+
+		return markdown, nil
+	})
+}
+
+// CalculatorTool evaluates arithmetic expressions.
+func CalculatorTool() Tool {
+	type args struct {
+		Expression string `json:"expression" description:"an arithmetic expression with + - * / %, parentheses and the functions abs, ceil, floor, round, sqrt, pow, exp, log, min and max"`
+	}
+
+	return NewTool("calculator", "Evaluates an arithmetic expression.", func(ctx context.Context, a args) (string, error) {
+		v, err := Calculate(a.Expression)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(v), nil
+	})
+}
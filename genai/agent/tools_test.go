@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchToolRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<p>internal</p>"))
+	}))
+	defer srv.Close()
+
+	tool := FetchTool(5 * time.Second)
+	args, _ := json.Marshal(map[string]string{"url": srv.URL})
+
+	got, err := tool.Call(context.Background(), args)
+	if err == nil {
+		t.Fatalf("got %q, want the loopback address refused", got)
+	}
+	if !strings.Contains(err.Error(), "not a public address") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFetchTool(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<h1>Title</h1><p>Some text.</p>"))
+	}))
+	defer srv.Close()
+
+	tool := fetchTool(srv.Client())
+	args, _ := json.Marshal(map[string]string{"url": srv.URL})
+
+	got, err := tool.Call(context.Background(), args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(got, "# Title") || !strings.Contains(got, "Some text.") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	tests := []struct {
+		address string
+		public  bool
+	}{
+		{address: "93.184.216.34:443", public: true},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443", public: true},
+		{address: "127.0.0.1:80"},
+		{address: "[::1]:80"},
+		{address: "10.0.0.1:80"},
+		{address: "172.16.0.1:80"},
+		{address: "192.168.1.1:80"},
+		{address: "169.254.169.254:80"},
+		{address: "[fe80::1]:80"},
+		{address: "[fd00::1]:80"},
+		{address: "0.0.0.0:80"},
+		{address: "[::ffff:127.0.0.1]:80"},
+		{address: "224.0.0.1:80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := publicOnly("tcp", tt.address, nil)
+			if tt.public && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tt.public && err == nil {
+				t.Error("got no error, want the address refused")
+			}
+		})
+	}
+}
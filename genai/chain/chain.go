@@ -0,0 +1,244 @@
+// Package chain composes the steps of a pipeline, like the retrieval,
+// prompting and checking of a RAG answer, into larger steps. A step is a
+// typed function of a context and an input; steps run one after the
+// other, pick one of two steps to run, or run side by side. Named steps
+// call the hooks carried by the context before and after they run, so a
+// whole pipeline can be traced without changing its steps.
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Step turns an input into an output. The context is passed on to every
+// step it runs.
+type Step[In, Out any] func(ctx context.Context, in In) (Out, error)
+
+// Pass is the step that returns its input.
+func Pass[T any]() Step[T, T] {
+	return func(ctx context.Context, in T) (T, error) {
+		return in, nil
+	}
+}
+
+// Then runs the second step on the output of the first.
+func Then[A, B, C any](first Step[A, B], second Step[B, C]) Step[A, C] {
+	return func(ctx context.Context, in A) (C, error) {
+		mid, err := first(ctx, in)
+		if err != nil {
+			var zero C
+			return zero, err
+		}
+		return second(ctx, mid)
+	}
+}
+
+// Seq runs steps of the same type one after the other, each on the output
+// of the one before.
+func Seq[T any](steps ...Step[T, T]) Step[T, T] {
+	return func(ctx context.Context, in T) (T, error) {
+		for _, step := range steps {
+			out, err := step(ctx, in)
+			if err != nil {
+				return out, err
+			}
+			in = out
+		}
+		return in, nil
+	}
+}
+
+// Branch runs the step yes on the inputs for which cond is true, and the
+// step no on the others.
+func Branch[In, Out any](cond func(ctx context.Context, in In) bool, yes Step[In, Out], no Step[In, Out]) Step[In, Out] {
+	return func(ctx context.Context, in In) (Out, error) {
+		if cond(ctx, in) {
+			return yes(ctx, in)
+		}
+		return no(ctx, in)
+	}
+}
+
+// All runs every step on the same input, at most parallel at once, and
+// returns their outputs in the order of the steps. The first error, in
+// that order, is returned once every step has stopped; the steps still
+// to run are cancelled.
+func All[In, Out any](parallel int, steps ...Step[In, Out]) Step[In, []Out] {
+	return func(ctx context.Context, in In) ([]Out, error) {
+		return each(ctx, parallel, len(steps), func(ctx context.Context, i int) (Out, error) {
+			return steps[i](ctx, in)
+		})
+	}
+}
+
+// Map runs a step on every input, at most parallel at once, and returns
+// the outputs in the order of the inputs. The first error, in that order,
+// is returned once every step has stopped, with the number of the input;
+// the steps still to run are cancelled.
+func Map[In, Out any](parallel int, step Step[In, Out]) Step[[]In, []Out] {
+	return func(ctx context.Context, in []In) ([]Out, error) {
+		return each(ctx, parallel, len(in), func(ctx context.Context, i int) (Out, error) {
+			out, err := step(ctx, in[i])
+			if err != nil {
+				return out, fmt.Errorf("item %d: %w", i+1, err)
+			}
+			return out, nil
+		})
+	}
+}
+
+// each runs fn for 0 to n-1, at most parallel at once, until one fails.
+func each[Out any](ctx context.Context, parallel int, n int, fn func(ctx context.Context, i int) (Out, error)) ([]Out, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outs := make([]Out, n)
+	errs := make([]error, n)
+
+	sem := make(chan struct{}, max(parallel, 1))
+	var wg sync.WaitGroup
+	for i := range n {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+
+		// A failed step cancels before it frees its slot, so the steps
+		// after it never start.
+		if err := ctx.Err(); err != nil {
+			<-sem
+			errs[i] = err
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			outs[i], errs[i] = fn(ctx, i)
+			if errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// An error of a step is more telling than the cancellation it caused.
+	var first error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if first == nil || (errors.Is(first, context.Canceled) && !errors.Is(err, context.Canceled)) {
+			first = err
+		}
+	}
+	if first != nil {
+		return nil, first
+	}
+	return outs, nil
+}
+
+// =============================================================================
+
+// Event is a named step starting or ending. Step is the path of the step,
+// the names of the steps it runs within joined by slashes. Output, Err and
+// Elapsed are set when it ends.
+type Event struct {
+	Step    string
+	Input   any
+	Output  any
+	Err     error
+	Elapsed time.Duration
+}
+
+// Hooks are called before and after every named step run with a context
+// that carries them. Either may be nil. They are called from the
+// goroutine running the step, so they have to be safe for concurrent use
+// with steps that run in parallel.
+type Hooks struct {
+	Before func(ctx context.Context, e Event)
+	After  func(ctx context.Context, e Event)
+}
+
+type hooksKey struct{}
+
+type pathKey struct{}
+
+// WithHooks returns a context that carries the hooks, in addition to the
+// ones of the parent context, which are called first.
+func WithHooks(ctx context.Context, hooks Hooks) context.Context {
+	parent, _ := ctx.Value(hooksKey{}).([]Hooks)
+	all := append(parent[:len(parent):len(parent)], hooks)
+	return context.WithValue(ctx, hooksKey{}, all)
+}
+
+// Path returns the path of the named step the context is running in,
+// empty outside of any.
+func Path(ctx context.Context) string {
+	path, _ := ctx.Value(pathKey{}).(string)
+	return path
+}
+
+// Named names a step, so the hooks of the context are called around it
+// and its errors are prefixed with its name.
+func Named[In, Out any](name string, step Step[In, Out]) Step[In, Out] {
+	return func(ctx context.Context, in In) (Out, error) {
+		path := name
+		if parent := Path(ctx); parent != "" {
+			path = parent + "/" + name
+		}
+		ctx = context.WithValue(ctx, pathKey{}, path)
+		hooks, _ := ctx.Value(hooksKey{}).([]Hooks)
+
+		e := Event{Step: path, Input: in}
+		for _, h := range hooks {
+			if h.Before != nil {
+				h.Before(ctx, e)
+			}
+		}
+
+		start := time.Now()
+		out, err := step(ctx, in)
+
+		e.Output, e.Err, e.Elapsed = out, err, time.Since(start)
+		for _, h := range hooks {
+			if h.After != nil {
+				h.After(ctx, e)
+			}
+		}
+
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", name, err)
+		}
+		return out, nil
+	}
+}
+
+// Trace returns hooks that write every named step that ends to w, with
+// the time it took or its error, indented by its depth.
+func Trace(w io.Writer) Hooks {
+	var mu sync.Mutex
+	return Hooks{
+		After: func(ctx context.Context, e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			indent := strings.Repeat("  ", strings.Count(e.Step, "/"))
+			if e.Err != nil {
+				fmt.Fprintf(w, "%s%s: failed after %s: %v\n", indent, e.Step, e.Elapsed.Round(time.Millisecond), e.Err)
+				return
+			}
+			fmt.Fprintf(w, "%s%s: %s\n", indent, e.Step, e.Elapsed.Round(time.Millisecond))
+		},
+	}
+}
@@ -0,0 +1,309 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// add returns a step that adds n to its input.
+func add(n int) Step[int, int] {
+	return func(ctx context.Context, in int) (int, error) {
+		return in + n, nil
+	}
+}
+
+// fail returns a step that fails with err, counting its calls.
+func fail(err error, calls *atomic.Int32) Step[int, int] {
+	return func(ctx context.Context, in int) (int, error) {
+		calls.Add(1)
+		return 0, err
+	}
+}
+
+func TestThen(t *testing.T) {
+	itoa := func(ctx context.Context, in int) (string, error) {
+		return fmt.Sprint(in), nil
+	}
+
+	got, err := Then(add(1), itoa)(context.Background(), 41)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "42" {
+		t.Errorf("got %q, want %q", got, "42")
+	}
+}
+
+func TestThenShortCircuits(t *testing.T) {
+	boom := errors.New("boom")
+	var first, second atomic.Int32
+
+	_, err := Then(fail(boom, &first), fail(errors.New("second"), &second))(context.Background(), 1)
+	if !errors.Is(err, boom) {
+		t.Errorf("got error %v, want %v", err, boom)
+	}
+	if first.Load() != 1 || second.Load() != 0 {
+		t.Errorf("called the steps %d and %d times, want 1 and 0", first.Load(), second.Load())
+	}
+}
+
+func TestSeq(t *testing.T) {
+	boom := errors.New("boom")
+
+	tests := []struct {
+		name      string
+		fail      bool
+		want      int
+		wantErr   error
+		wantCalls int32
+	}{
+		{name: "runs every step in order", want: 111},
+		{name: "stops at the first error", fail: true, wantErr: boom, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls, after atomic.Int32
+			middle := add(10)
+			if tt.fail {
+				middle = fail(boom, &calls)
+			}
+			last := func(ctx context.Context, in int) (int, error) {
+				after.Add(1)
+				return in + 100, nil
+			}
+
+			got, err := Seq(add(1), middle, last)(context.Background(), 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if calls.Load() != tt.wantCalls || after.Load() != 0 {
+					t.Errorf("called the failing step %d times and the last %d times, want %d and 0", calls.Load(), after.Load(), tt.wantCalls)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBranch(t *testing.T) {
+	big := func(ctx context.Context, in int) bool { return in > 10 }
+	step := Branch(big, add(1000), Pass[int]())
+
+	tests := []struct {
+		in   int
+		want int
+	}{
+		{in: 5, want: 5},
+		{in: 11, want: 1011},
+	}
+
+	for _, tt := range tests {
+		got, err := step(context.Background(), tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Branch(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	const parallel = 3
+
+	// Every step waits until parallel steps run at once, or times out.
+	var mu sync.Mutex
+	var running, peak int
+	full := make(chan struct{})
+	var once sync.Once
+	square := func(ctx context.Context, in int) (int, error) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		if running == parallel {
+			once.Do(func() { close(full) })
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+
+		select {
+		case <-full:
+		case <-time.After(time.Second):
+		}
+		return in * in, nil
+	}
+
+	got, err := Map(parallel, square)(context.Background(), []int{1, 2, 3, 4, 5, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 4, 9, 16, 25, 36}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if peak != parallel {
+		t.Errorf("ran %d steps at once, want %d", peak, parallel)
+	}
+}
+
+func TestMapCancelsOnError(t *testing.T) {
+	boom := errors.New("boom")
+	var started atomic.Int32
+	var cancelled atomic.Int32
+
+	step := func(ctx context.Context, in int) (int, error) {
+		started.Add(1)
+		if in == 2 {
+			return 0, boom
+		}
+
+		// The others run until the failure cancels them.
+		select {
+		case <-ctx.Done():
+			cancelled.Add(1)
+			return 0, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return in, nil
+		}
+	}
+
+	start := time.Now()
+	_, err := Map(2, step)(context.Background(), []int{1, 2, 3, 4, 5})
+	if !errors.Is(err, boom) {
+		t.Fatalf("got error %v, want %v", err, boom)
+	}
+	if !strings.Contains(err.Error(), "item 2") {
+		t.Errorf("got error %q, want it to name item 2", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s, want the failure to cancel the other steps", elapsed)
+	}
+	if started.Load() != 2 || cancelled.Load() != 1 {
+		t.Errorf("started %d steps and cancelled %d, want 2 and 1", started.Load(), cancelled.Load())
+	}
+}
+
+func TestAll(t *testing.T) {
+	got, err := All(2, add(1), add(2), add(3))(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{11, 12, 13}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAllReturnsFirstError(t *testing.T) {
+	first := errors.New("first")
+	second := errors.New("second")
+	var calls atomic.Int32
+
+	// The second step fails after the third, but comes first in order.
+	slow := func(ctx context.Context, in int) (int, error) {
+		time.Sleep(50 * time.Millisecond)
+		return 0, first
+	}
+
+	_, err := All(3, add(1), slow, fail(second, &calls))(context.Background(), 0)
+	if !errors.Is(err, first) {
+		t.Errorf("got error %v, want %v", err, first)
+	}
+}
+
+func TestNamedHooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	record := func(kind string) func(ctx context.Context, e Event) {
+		return func(ctx context.Context, e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			event := fmt.Sprintf("%s %s %v", kind, e.Step, e.Input)
+			if kind == "after" {
+				event += fmt.Sprintf(" -> %v", e.Output)
+			}
+			events = append(events, event)
+		}
+	}
+
+	ctx := WithHooks(context.Background(), Hooks{Before: record("before"), After: record("after")})
+	ctx = WithHooks(ctx, Hooks{After: record("second")})
+
+	pipeline := Named("outer", Then(Named("one", add(1)), Named("two", add(2))))
+	got, err := pipeline(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 3 {
+		t.Errorf("got %d, want 3", got)
+	}
+
+	want := []string{
+		"before outer 0",
+		"before outer/one 0",
+		"after outer/one 0 -> 1",
+		"second outer/one 0",
+		"before outer/two 1",
+		"after outer/two 1 -> 3",
+		"second outer/two 1",
+		"after outer 0 -> 3",
+		"second outer 0",
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("got events\n%s\nwant\n%s", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestNamedErrors(t *testing.T) {
+	boom := errors.New("boom")
+	var calls atomic.Int32
+	var failed Event
+
+	ctx := WithHooks(context.Background(), Hooks{
+		After: func(ctx context.Context, e Event) {
+			if e.Step == "outer/inner" {
+				failed = e
+			}
+		},
+	})
+
+	_, err := Named("outer", Named("inner", fail(boom, &calls)))(ctx, 0)
+	if !errors.Is(err, boom) {
+		t.Fatalf("got error %v, want %v", err, boom)
+	}
+	if want := "outer: inner: boom"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+	if failed.Err != boom {
+		t.Errorf("got hook error %v, want %v unprefixed", failed.Err, boom)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var b strings.Builder
+	ctx := WithHooks(context.Background(), Trace(&b))
+
+	if _, err := Named("outer", Named("inner", add(1)))(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "  outer/inner: ") || !strings.HasPrefix(lines[1], "outer: ") {
+		t.Errorf("got trace\n%s", b.String())
+	}
+}
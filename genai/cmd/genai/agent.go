@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dwhitena/go-genai-webinar/genai/agent"
+	"github.com/dwhitena/go-genai-webinar/genai/chain"
+)
+
+// runAgent answers a question with a model that searches the index,
+// fetches web pages and calculates as it sees fit.
+func runAgent(ctx context.Context, args []string) error {
+	var cfg config
+
+	fs := newFlagSet("agent", "<question>", &cfg)
+	maxSteps := fs.Int("max-steps", 8, "maximum number of steps before giving up")
+	trace := fs.Bool("trace", false, "write the tools called and the steps of the chains they run, with the time they took, to stderr")
+	fs.Parse(args)
+
+	question := strings.Join(fs.Args(), " ")
+	if question == "" {
+		fs.Usage()
+		return errors.New("missing question")
+	}
+
+	r, err := cfg.rag(ctx)
+	if err != nil {
+		return err
+	}
+
+	a := agent.Agent{
+		Client: r.Client,
+		Model:  r.Model,
+		Tools: []agent.Tool{
+			agent.SearchTool(r),
+			agent.AskTool(r),
+			agent.FetchTool(cfg.timeout),
+			agent.CalculatorTool(),
+		},
+		MaxSteps: *maxSteps,
+		Repairs:  2,
+		Log:      os.Stderr,
+	}
+
+	if *trace {
+		ctx = chain.WithHooks(ctx, chain.Trace(os.Stderr))
+	}
+
+	result, err := chain.Named("agent", a.Run)(ctx, question)
+	if err != nil {
+		return err
+	}
+	fmt.Println(result.Answer)
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
+)
+
+// runAsk answers a single question from the index.
+func runAsk(ctx context.Context, args []string) error {
+	var cfg config
+
+	fs := newFlagSet("ask", "<question>", &cfg)
+	factuality := fs.Bool("factuality", false, "score the factuality of the answer")
+	samples := fs.Int("samples", 1, "number of answers to sample and vote on, at the temperature of the model")
+	parallel := fs.Int("parallel", 4, "maximum number of samples answered at once")
+	vote := fs.String("vote", "normalized", "how samples are voted on: exact, normalized or judge")
+	fs.Parse(args)
+
+	question := strings.Join(fs.Args(), " ")
+	if question == "" {
+		fs.Usage()
+		return errors.New("missing question")
+	}
+
+	r, err := cfg.rag(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, records := llm.WithRecords(ctx)
+
+	var resp rag.Response
+	if *samples > 1 {
+		sc := llm.SelfConsistency{Samples: *samples, Parallel: *parallel}
+		switch *vote {
+		case "exact":
+			sc.Aggregate = llm.MajorityVote(nil)
+		case "normalized":
+			sc.Aggregate = llm.MajorityVote(llm.NormalizeAnswer)
+		case "judge":
+			sc.Aggregate = llm.JudgeVote(r.Client, r.Model, r.Prompts)
+		default:
+			return fmt.Errorf("unknown vote %q, expected exact, normalized or judge", *vote)
+		}
+
+		var consensus llm.Consensus
+		resp, consensus, err = r.Sample(ctx, question, nil, sc)
+		if err != nil {
+			return err
+		}
+		fmt.Print(resp.Answer)
+		fmt.Printf("\n\n(agreement %.0f%% of %d samples", consensus.Agreement*100, len(consensus.Answers))
+		if consensus.Failed > 0 {
+			fmt.Printf(", %d failed", consensus.Failed)
+		}
+		fmt.Print(")")
+	} else {
+		resp, err = r.Ask(ctx, question, nil, os.Stdout)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Print("\n")
+	if resp.FinishReason == "length" {
+		fmt.Print("\n(answer cut short, raise -max-tokens for more)\n")
+	}
+	escalated(os.Stdout, resp)
+	for _, record := range records.List() {
+		if len(record.Failed) > 0 {
+			fmt.Printf("\n(%s)\n", record)
+		}
+	}
+
+	if *factuality {
+		score, err := r.Factuality(ctx, resp.Results, resp.Answer)
+		if err != nil {
+			return err
+		}
+		fmt.Print("\nFactuality Score: ", score, "\n")
+	}
+
+	return nil
+}
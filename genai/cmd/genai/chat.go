@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dwhitena/go-genai-webinar/genai/memory"
+)
+
+// runChat answers questions from the index interactively, until "exit" is
+// typed or the input ends.
+func runChat(ctx context.Context, args []string) error {
+	var cfg config
+
+	fs := newFlagSet("chat", "", &cfg)
+	factuality := fs.Bool("factuality", false, "score the factuality of each answer")
+	strategy := fs.String("memory", "tokens", "what is remembered of the conversation: window, tokens, summary or summary-buffer")
+	turns := fs.Int("memory-turns", 5, "number of the latest turns remembered by the window memory")
+	tokens := fs.Int("memory-tokens", 2000, "maximum number of tokens of the latest turns remembered by the tokens and summary-buffer memories")
+	fs.Parse(args)
+
+	r, err := cfg.rag(ctx)
+	if err != nil {
+		return err
+	}
+
+	var mem memory.Memory
+	switch *strategy {
+	case "window":
+		mem = &memory.Window{Turns: *turns}
+	case "tokens":
+		mem = &memory.TokenBuffer{Budget: *tokens}
+	case "summary":
+		mem = &memory.Summary{Client: r.Client, Model: r.Model, Prompts: r.Prompts}
+	case "summary-buffer":
+		mem = &memory.Summary{Client: r.Client, Model: r.Model, Prompts: r.Prompts, Budget: *tokens}
+	default:
+		return fmt.Errorf("unknown memory %q, expected window, tokens, summary or summary-buffer", *strategy)
+	}
+
+	// Start a cycle of listening for questions and responding to the questions.
+	fmt.Println("")
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("🧑: ")
+		if !scanner.Scan() {
+			break
+		}
+		input := scanner.Text()
+
+		// Exit if we type "exit".
+		if strings.ToLower(input) == "exit" {
+			break
+		}
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+
+		// Print the bot response.
+		fmt.Print("\n🤖: ")
+		resp, err := r.Ask(ctx, input, mem.Messages(), os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		if *factuality {
+			score, err := r.Factuality(ctx, resp.Results, resp.Answer)
+			if err != nil {
+				return err
+			}
+			fmt.Print("\n\nFactuality Score: ", score)
+		}
+		fmt.Print("\n")
+		escalated(os.Stdout, resp)
+		fmt.Print("\n")
+
+		// Remember the question and answer. A memory that fails to
+		// summarize keeps the turns to summarize them with the next one.
+		if err := mem.Add(ctx, memory.Turn{Question: resp.Question, Answer: resp.Answer}); err != nil {
+			fmt.Fprintf(os.Stderr, "(%s)\n\n", err)
+		}
+	}
+
+	cfg.report()
+
+	return scanner.Err()
+}
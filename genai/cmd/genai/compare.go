@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dwhitena/go-genai-webinar/genai/eval"
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+)
+
+// runCompare answers the questions of a dataset with two or more variants
+// of the prompts and writes a report of their answers side by side, with
+// the win rates of the variants judged by a model.
+func runCompare(ctx context.Context, args []string) error {
+	var cfg config
+	var variants stringsFlag
+
+	fs := newFlagSet("compare", "<dataset.jsonl>", &cfg)
+	fs.Var(&variants, "variant", "variant of the prompts, like qa=qa.v1,system=system.v1 (repeated, at least two)")
+	metrics := fs.String("metrics", "exact,contains,regex", "comma-separated metrics: exact, contains, regex and judge")
+	judgeModel := fs.String("judge-model", "", "model that judges which answer is better (default the model)")
+	noJudge := fs.Bool("no-judge", false, "compare the metrics only, without judging the answers")
+	parallel := fs.Int("parallel", 4, "maximum number of items answered or judged at once")
+	format := fs.String("format", "markdown", "format of the report: markdown or html")
+	out := fs.String("out", "", "file to write the report to (default standard output)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing dataset")
+	}
+	dataset := fs.Arg(0)
+
+	if len(variants) < 2 {
+		return errors.New("compare needs at least two variants")
+	}
+	if *format != "markdown" && *format != "html" {
+		return fmt.Errorf("unknown format %q, expected markdown or html", *format)
+	}
+
+	items, err := eval.LoadDataset(dataset)
+	if err != nil {
+		return fmt.Errorf("loading dataset: %w", err)
+	}
+
+	// Items with their own context do not need the index.
+	cfg.optionalIndex = true
+	for _, item := range items {
+		if item.Context == "" {
+			cfg.optionalIndex = false
+		}
+	}
+
+	r, err := cfg.rag(ctx)
+	if err != nil {
+		return err
+	}
+
+	judge := r.Model
+	if *judgeModel != "" {
+		if judge, err = cfg.spec(*judgeModel, llm.OpChat); err != nil {
+			return err
+		}
+	}
+	ms, err := eval.Metrics(strings.Split(*metrics, ","), r.Client, judge, r.Prompts)
+	if err != nil {
+		return err
+	}
+
+	c := eval.Comparer{
+		Metrics:  ms,
+		Parallel: *parallel,
+	}
+	if !*noJudge {
+		c.Judge = eval.PairwiseJudge(r.Client, judge, r.Prompts)
+	}
+
+	seen := map[string]bool{}
+	for _, variant := range variants {
+		v := r
+		for _, setting := range strings.Split(variant, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(setting), "=")
+			switch key {
+			case "system":
+				v.System = value
+			case "qa":
+				v.QA = value
+			default:
+				return fmt.Errorf("variant %q: unknown setting %q, expected system or qa", variant, key)
+			}
+		}
+		for _, name := range []string{v.System, v.QA} {
+			if _, err := r.Prompts.Get(name); err != nil {
+				return fmt.Errorf("variant %q: %w", variant, err)
+			}
+		}
+
+		name := promptID(r.Prompts, v.System) + "+" + promptID(r.Prompts, v.QA)
+		if seen[name] {
+			return fmt.Errorf("variant %q: same prompts as another variant", variant)
+		}
+		seen[name] = true
+
+		c.Variants = append(c.Variants, eval.Variant{Name: name, Answer: answerer(v)})
+	}
+
+	comparison := c.Run(ctx, items)
+	comparison.Config["dataset"] = dataset
+	comparison.Config["model"] = r.Model.Name()
+	if c.Judge != nil {
+		comparison.Config["judge"] = judge.Name()
+	}
+	if cfg.examples != "" {
+		comparison.Config["examples"] = cfg.examples
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "html" {
+		err = comparison.WriteHTML(w)
+	} else {
+		err = comparison.WriteMarkdown(w)
+	}
+	if err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dwhitena/go-genai-webinar/genai/eval"
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
+)
+
+// runEval answers the questions of a dataset with the prompts and model
+// and scores the answers against the expected ones.
+func runEval(ctx context.Context, args []string) error {
+	var cfg config
+
+	fs := newFlagSet("eval", "<dataset.jsonl>", &cfg)
+	metrics := fs.String("metrics", "exact,contains,regex", "comma-separated metrics: exact, contains, regex and judge")
+	judgeModel := fs.String("judge-model", "", "model of the judge metric (default the model)")
+	parallel := fs.Int("parallel", 4, "maximum number of items answered at once")
+	out := fs.String("out", "", "JSON file to save the report to, to compare later runs against")
+	baseline := fs.String("compare", "", "JSON report of a previous run to compare this run against")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing dataset")
+	}
+	dataset := fs.Arg(0)
+
+	items, err := eval.LoadDataset(dataset)
+	if err != nil {
+		return fmt.Errorf("loading dataset: %w", err)
+	}
+
+	// Items with their own context do not need the index.
+	cfg.optionalIndex = true
+	for _, item := range items {
+		if item.Context == "" {
+			cfg.optionalIndex = false
+		}
+	}
+
+	r, err := cfg.rag(ctx)
+	if err != nil {
+		return err
+	}
+
+	judge := r.Model
+	if *judgeModel != "" {
+		if judge, err = cfg.spec(*judgeModel, llm.OpChat); err != nil {
+			return err
+		}
+	}
+	ms, err := eval.Metrics(strings.Split(*metrics, ","), r.Client, judge, r.Prompts)
+	if err != nil {
+		return err
+	}
+
+	runner := eval.Runner{
+		Answer:   answerer(r),
+		Metrics:  ms,
+		Parallel: *parallel,
+	}
+
+	report := runner.Run(ctx, items)
+	report.Config = map[string]string{
+		"dataset": dataset,
+		"model":   r.Model.Name(),
+		"system":  promptID(r.Prompts, cfg.system),
+		"qa":      promptID(r.Prompts, cfg.qa),
+	}
+	if cfg.examples != "" {
+		report.Config["examples"] = cfg.examples
+	}
+
+	fmt.Printf("Evaluated %s with %s, prompts %s and %s\n\n", dataset, report.Config["model"], report.Config["system"], report.Config["qa"])
+	if err := report.WriteText(os.Stdout); err != nil {
+		return err
+	}
+
+	if *baseline != "" {
+		base, err := eval.LoadReport(*baseline)
+		if err != nil {
+			return fmt.Errorf("loading baseline: %w", err)
+		}
+		fmt.Printf("\nCompared to %s (%s, prompts %s and %s):\n\n", *baseline, base.Config["model"], base.Config["system"], base.Config["qa"])
+		if err := eval.Compare(os.Stdout, base, report); err != nil {
+			return err
+		}
+	}
+
+	if *out != "" {
+		if err := report.Save(*out); err != nil {
+			return fmt.Errorf("saving report: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// answerer answers the items of a dataset with the pipeline, from the
+// context of an item or else from the chunks retrieved for its question.
+func answerer(r rag.RAG) eval.Answerer {
+	return func(ctx context.Context, item eval.Item) (eval.Answer, error) {
+		results := []rag.Result{{Chunk: rag.VectorizedChunk{Chunk: item.Context}}}
+		if item.Context == "" {
+			var err error
+			if results, err = r.Search(ctx, item.Question); err != nil {
+				return eval.Answer{}, err
+			}
+		}
+
+		answer, err := r.Answer(ctx, item.Question, results, nil, io.Discard)
+		if err != nil {
+			return eval.Answer{}, err
+		}
+		return eval.Answer{Text: answer.Content, Usage: answer.Usage}, nil
+	}
+}
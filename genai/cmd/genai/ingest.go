@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
+)
+
+// runIngest downloads, chunks and embeds the sources into the index. A
+// re-run only embeds the chunks that are new or changed.
+func runIngest(ctx context.Context, args []string) error {
+	var cfg config
+	var sources stringsFlag
+
+	fs := newFlagSet("ingest", "", &cfg)
+	fs.Var(&sources, "source", "website URL or Go package pattern (like ./...) to index, can be repeated (default the Go contribution guide)")
+	similarity := fs.Float64("similarity", 0.95, "similarity (0-1) above which chunks are near-duplicates")
+	dryRun := fs.Bool("dry-run", false, "only estimate the tokens and cost of embedding the new or changed chunks")
+	fs.Parse(args)
+
+	srcs := rag.DefaultSources
+	if len(sources) > 0 {
+		var err error
+		if srcs, err = rag.ParseSources(sources); err != nil {
+			return err
+		}
+	}
+
+	previous, err := rag.LoadIndex(cfg.index)
+	if err != nil {
+		return fmt.Errorf("loading index: %w", err)
+	}
+
+	cln, err := cfg.client()
+	if err != nil {
+		return err
+	}
+
+	spec, err := cfg.spec(llm.EmbeddingModel, llm.OpEmbedding)
+	if err != nil {
+		return err
+	}
+
+	ing := rag.Ingester{
+		Embedder: rag.Embedder{
+			Client: cln,
+			Model:  spec,
+		},
+		Similarity: *similarity,
+		DryRun:     *dryRun,
+		Progress:   os.Stdout,
+	}
+
+	if *dryRun {
+		_, summary, err := ing.Ingest(ctx, previous, srcs)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nWould embed %d chunks, about %d tokens", summary.Pending, summary.PendingTokens)
+		embedding := llm.ModelUsage{
+			Model: llm.EmbeddingModel,
+			Usage: llm.Usage{PromptTokens: summary.PendingTokens},
+		}
+		if cost, priced := cfg.meter.Prices.Cost([]llm.ModelUsage{embedding}); priced {
+			fmt.Printf(" for $%.4f", cost)
+		}
+		fmt.Print("\n")
+		return nil
+	}
+
+	// Save whatever was embedded, even when the ingestion failed part way,
+	// so a re-run picks up where it stopped.
+	idx, summary, err := ing.Ingest(ctx, previous, srcs)
+	var partial *rag.PartialError
+	if err != nil && !errors.As(err, &partial) {
+		return err
+	}
+
+	if err := idx.Save(cfg.index); err != nil {
+		return fmt.Errorf("saving index: %w", err)
+	}
+
+	fmt.Printf("\nIndexed %d chunks: %s\n", len(idx.Chunks), summary)
+	cfg.report()
+
+	return err
+}
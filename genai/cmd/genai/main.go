@@ -0,0 +1,503 @@
+// Command genai ingests sources into a vector index and answers questions
+// from it, so the inputs of the workshop examples no longer need to be
+// edited in source.
+//
+// Usage:
+//
+//	genai <command> [flags] [arguments]
+//
+// The commands are:
+//
+//	ingest    download, chunk and embed sources into the index
+//	search    print the chunks of the index most similar to a query
+//	ask       answer a single question from the index
+//	chat      answer questions from the index interactively
+//	serve     serve search and ask over HTTP
+//	agent     answer a question with a model that uses tools
+//	eval      score the answers to a dataset of questions
+//	compare   compare variants of the prompts over a dataset of questions
+//	summarize summarize a long document, or answer a question about it
+//
+// The Prediction Guard API key is read from the PGKEY environment variable.
+// Run "genai <command> -h" for the flags of a command.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
+)
+
+// command is a subcommand of genai.
+type command struct {
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+// commands are the subcommands of genai by name.
+var commands = map[string]command{
+	"ingest":    {"download, chunk and embed sources into the index", runIngest},
+	"search":    {"print the chunks of the index most similar to a query", runSearch},
+	"ask":       {"answer a single question from the index", runAsk},
+	"chat":      {"answer questions from the index interactively", runChat},
+	"serve":     {"serve search and ask over HTTP", runServe},
+	"agent":     {"answer a question with a model that uses tools", runAgent},
+	"eval":      {"score the answers to a dataset of questions", runEval},
+	"compare":   {"compare variants of the prompts over a dataset of questions", runCompare},
+	"summarize": {"summarize a long document, or answer a question about it", runSummarize},
+}
+
+// errUsage is returned when genai is called with the wrong arguments.
+var errUsage = errors.New("usage")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: genai <command> [flags] [arguments]\n\nThe commands are:\n\n")
+
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "\t%-9s %s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nRun \"genai <command> -h\" for the flags of a command.\n")
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		log.Fatalln(err)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		usage()
+		return errUsage
+	}
+
+	cmd, exists := commands[args[0]]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "genai: unknown command %q\n\n", args[0])
+		usage()
+		return errUsage
+	}
+
+	return cmd.run(ctx, args[1:])
+}
+
+// =============================================================================
+
+// config holds the settings shared by the commands.
+type config struct {
+	host        string
+	index       string
+	model       string
+	fallback    string
+	fallbackTO  time.Duration
+	models      string
+	topK        int
+	temperature float64
+	maxTokens   int
+	timeout     time.Duration
+	retries     int
+	rate        float64
+	burst       int
+	prices      string
+	cache       string
+	cacheTTL    time.Duration
+	noCache     bool
+	semantic    float64
+	prompts     string
+	examples    string
+	shots       int
+	shotTokens  int
+	system      string
+	qa          string
+	escalate    string
+	refusalSim  float64
+	verbose     bool
+
+	// optionalIndex is set by commands that can do without an index.
+	optionalIndex bool
+
+	flags *flag.FlagSet
+	meter *llm.Meter
+	cln   llm.Client
+}
+
+// cacheSize is the number of responses kept by the in-memory cache.
+const cacheSize = 1000
+
+// newFlagSet constructs the flag set of a command with the shared flags
+// bound to cfg.
+func newFlagSet(name string, arguments string, cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: genai %s [flags] %s\n\n", name, arguments)
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&cfg.host, "host", "https://api.predictionguard.com", "Prediction Guard API host")
+	fs.StringVar(&cfg.index, "index", "chunks.json", "path of the vector index")
+	fs.StringVar(&cfg.model, "model", envOr(llm.ModelEnv, llm.DefaultModel), "model used to answer questions, also set by $"+llm.ModelEnv)
+	fs.StringVar(&cfg.fallback, "fallback", "", "comma-separated models to fall back to, in order, when the model fails")
+	fs.DurationVar(&cfg.fallbackTO, "fallback-timeout", 0, "time the model and each fallback model get before the next one is tried (default an equal share of -timeout)")
+	fs.StringVar(&cfg.models, "models", "", "JSON file of model specs that add to or replace the known models")
+	fs.IntVar(&cfg.topK, "top-k", 3, "number of chunks to retrieve for a question")
+	fs.Float64Var(&cfg.temperature, "temperature", 0, "sampling temperature of the model (default from the model)")
+	fs.IntVar(&cfg.maxTokens, "max-tokens", 0, "maximum number of tokens in an answer (default from the model)")
+	fs.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "timeout of each call to the API")
+	fs.IntVar(&cfg.retries, "retries", 5, "maximum number of attempts of a failing call to the API")
+	fs.Float64Var(&cfg.rate, "rate", 5, "maximum calls per second to each model (0 for no limit)")
+	fs.IntVar(&cfg.burst, "burst", 5, "maximum burst of calls to each model")
+	fs.StringVar(&cfg.prices, "prices", "", "JSON price table of the models in dollars per million tokens, to report costs")
+	fs.StringVar(&cfg.cache, "cache", "memory", "cache of the responses of the API: memory, disk or off")
+	fs.DurationVar(&cfg.cacheTTL, "cache-ttl", 24*time.Hour, "time the cached responses are served for (0 for ever)")
+	fs.BoolVar(&cfg.noCache, "no-cache", false, "call the API instead of serving cached responses, and refresh the cache")
+	fs.Float64Var(&cfg.semantic, "semantic-cache", 0, "similarity (0-1) above which a question gets the answer of a previous one (0 to disable)")
+	fs.StringVar(&cfg.prompts, "prompts", os.Getenv(prompt.Env), "directory of prompt templates that add to or replace the default ones, also set by $"+prompt.Env)
+	fs.StringVar(&cfg.examples, "examples", "", "JSON file of worked examples, objects with an input and an output, to show the model before a question")
+	fs.IntVar(&cfg.shots, "shots", 3, "number of the examples most similar to a question to show the model")
+	fs.IntVar(&cfg.shotTokens, "shot-tokens", 1000, "maximum number of tokens of the examples shown for a question (0 for no limit)")
+	fs.StringVar(&cfg.system, "system-prompt", rag.SystemPrompt, "name of the system prompt, with an optional version like system.v1")
+	fs.StringVar(&cfg.qa, "qa-prompt", rag.QAPrompt, "name of the prompt of a question about the context, with an optional version like qa.v1")
+	fs.StringVar(&cfg.escalate, "escalate", "widen,rewrite,hybrid", "comma-separated retries of a refused question, in order: widen, rewrite and hybrid (off to show refusals)")
+	fs.Float64Var(&cfg.refusalSim, "refusal-similarity", 0, "similarity (0-1) to the refusal sentence above which an answer is a refusal in other words (0 to only match the sentence)")
+	fs.BoolVar(&cfg.verbose, "v", false, "log the calls to the API")
+
+	cfg.flags = fs
+
+	return fs
+}
+
+// envOr returns the value of an environment variable, or a default when
+// it is not set.
+func envOr(key string, value string) string {
+	if v, exists := os.LookupEnv(key); exists && v != "" {
+		return v
+	}
+	return value
+}
+
+// logger logs the messages of the client in verbose mode.
+func (cfg config) logger(ctx context.Context, msg string, v ...any) {
+	if !cfg.verbose {
+		return
+	}
+	s := fmt.Sprintf("msg: %s", msg)
+	for i := 0; i+1 < len(v); i = i + 2 {
+		s = s + fmt.Sprintf(", %s: %v", v[i], v[i+1])
+	}
+	log.Println(s)
+}
+
+// client constructs a client for the Prediction Guard API that serves
+// repeated calls from the cache, falls back to other models, retries
+// failed calls, rate limits the calls to each model and meters their
+// usage. The client is constructed once, so the usage of a command is
+// reported as a whole.
+func (cfg *config) client() (llm.Client, error) {
+	if cfg.cln != nil {
+		return cfg.cln, nil
+	}
+
+	var prices llm.Prices
+	if cfg.prices != "" {
+		var err error
+		if prices, err = llm.LoadPrices(cfg.prices); err != nil {
+			return nil, fmt.Errorf("loading prices: %w", err)
+		}
+	}
+
+	cfg.meter = &llm.Meter{
+		Client: &llm.Retry{
+			Client:      llm.NewClient(cfg.logger, cfg.host, os.Getenv("PGKEY")),
+			Limiter:     llm.NewLimiter(cfg.rate, cfg.burst),
+			MaxAttempts: cfg.retries,
+			Timeout:     cfg.timeout,
+			Log:         cfg.logger,
+		},
+		Prices: prices,
+	}
+
+	var cln llm.Client = cfg.meter
+	if cfg.fallback != "" {
+		router, err := cfg.router()
+		if err != nil {
+			return nil, err
+		}
+		cln = router
+	}
+
+	var cache llm.Cache
+	switch cfg.cache {
+	case "off":
+		cfg.cln = cln
+		return cfg.cln, nil
+	case "memory":
+		cache = llm.NewLRU(cacheSize)
+	case "disk":
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		cache = llm.DiskCache{Dir: filepath.Join(dir, "genai")}
+	default:
+		return nil, fmt.Errorf("unknown cache %q, expected memory, disk or off", cfg.cache)
+	}
+
+	cfg.cln = &llm.Cached{
+		Client: cln,
+		Cache:  cache,
+		TTL:    cfg.cacheTTL,
+		Bypass: cfg.noCache,
+	}
+
+	return cfg.cln, nil
+}
+
+// router constructs a router that calls the model and then the fallback
+// models in order. Each model gets its share of the timeout, so one that
+// hangs leaves the next ones time to answer.
+func (cfg *config) router() (*llm.Router, error) {
+	router := llm.Router{
+		Client: cfg.meter,
+		Log:    cfg.logger,
+	}
+
+	var names []string
+	for _, name := range append([]string{cfg.model}, strings.Split(cfg.fallback, ",")...) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	timeout := cfg.fallbackTO
+	if timeout == 0 {
+		timeout = cfg.timeout / time.Duration(len(names))
+	}
+
+	for _, name := range names {
+		spec, err := cfg.spec(name, llm.OpChat)
+		if err != nil {
+			return nil, fmt.Errorf("fallback: %w", err)
+		}
+		router.Backends = append(router.Backends, llm.Backend{
+			Name:    spec.Name(),
+			Client:  cfg.meter,
+			Model:   spec,
+			Timeout: timeout,
+		})
+	}
+
+	return &router, nil
+}
+
+// report prints the usage of the calls made by the command.
+func (cfg *config) report() {
+	if cfg.meter == nil {
+		return
+	}
+	fmt.Printf("\nUsage:\n%s\n", cfg.meter.Report())
+}
+
+// spec looks up the model selected for an operation in the registry.
+func (cfg *config) spec(name string, op llm.Operation) (llm.ModelSpec, error) {
+	registry := llm.DefaultRegistry
+	if cfg.models != "" {
+		var err error
+		if registry, err = llm.LoadRegistry(cfg.models); err != nil {
+			return llm.ModelSpec{}, fmt.Errorf("loading models: %w", err)
+		}
+	}
+
+	return registry.Lookup(name, op)
+}
+
+// rag loads the index and constructs the pipeline to answer questions.
+// The parameters of the model apply unless they are set by flags.
+func (cfg *config) rag(ctx context.Context) (rag.RAG, error) {
+	spec, err := cfg.spec(cfg.model, llm.OpChat)
+	if err != nil {
+		return rag.RAG{}, err
+	}
+
+	embedding, err := cfg.spec(llm.EmbeddingModel, llm.OpEmbedding)
+	if err != nil {
+		return rag.RAG{}, err
+	}
+
+	params := spec.Defaults
+	cfg.flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "max-tokens":
+			params.MaxTokens = cfg.maxTokens
+		case "temperature":
+			params.Temperature = float32(cfg.temperature)
+		}
+	})
+
+	chunks, err := rag.LoadChunks(cfg.index)
+	if err != nil {
+		return rag.RAG{}, fmt.Errorf("loading index: %w", err)
+	}
+	if len(chunks) == 0 && !cfg.optionalIndex {
+		return rag.RAG{}, fmt.Errorf("index %s is empty, run genai ingest first", cfg.index)
+	}
+
+	prompts, err := prompt.FromDir(cfg.prompts)
+	if err != nil {
+		return rag.RAG{}, fmt.Errorf("loading prompts: %w", err)
+	}
+	for _, name := range []string{cfg.system, cfg.qa} {
+		if _, err := prompts.Get(name); err != nil {
+			return rag.RAG{}, err
+		}
+	}
+
+	cln, err := cfg.client()
+	if err != nil {
+		return rag.RAG{}, err
+	}
+
+	r := rag.RAG{
+		Client:      cln,
+		Chunks:      chunks,
+		Model:       spec,
+		Embedding:   embedding,
+		TopK:        cfg.topK,
+		MaxTokens:   params.MaxTokens,
+		Temperature: params.Temperature,
+		Timeout:     cfg.timeout,
+		Prompts:     prompts,
+		System:      cfg.system,
+		QA:          cfg.qa,
+	}
+	if cfg.semantic > 0 {
+		r.Semantic = &rag.SemanticCache{Threshold: cfg.semantic}
+	}
+	if cfg.escalate != "off" {
+		if r.Refusal, err = rag.NewRefusal(prompts); err != nil {
+			return rag.RAG{}, err
+		}
+		if cfg.refusalSim > 0 {
+			r.Refusal.Embedder = &rag.Embedder{Client: cln, Model: embedding}
+			r.Refusal.Threshold = cfg.refusalSim
+		}
+		if r.Escalations, err = escalations(cfg.escalate); err != nil {
+			return rag.RAG{}, err
+		}
+	}
+	if cfg.examples != "" {
+		if r.FewShot, err = cfg.fewShot(ctx, rag.Embedder{Client: cln, Model: embedding}); err != nil {
+			return rag.RAG{}, err
+		}
+	}
+
+	return r, nil
+}
+
+// escalations returns the escalations of a refusal by name.
+func escalations(names string) ([]rag.Escalation, error) {
+	var steps []rag.Escalation
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "widen":
+			steps = append(steps, rag.Widen(3))
+		case "rewrite":
+			steps = append(steps, rag.Rewrite())
+		case "hybrid":
+			steps = append(steps, rag.Hybrid())
+		default:
+			return nil, fmt.Errorf("unknown escalation %q, expected widen, rewrite or hybrid", name)
+		}
+	}
+	return steps, nil
+}
+
+// escalated writes the escalation path of a response that was retried
+// after a refusal.
+func escalated(w io.Writer, resp rag.Response) {
+	if len(resp.Attempts) < 2 {
+		return
+	}
+	fmt.Fprint(w, "\n(escalated after a refusal:")
+	for _, attempt := range resp.Attempts {
+		fmt.Fprintf(w, "\n  %s", attempt)
+	}
+	fmt.Fprint(w, ")\n")
+}
+
+// fewShot loads the worked examples and embeds the ones that are new or
+// changed, saving their vectors back to the file for the next run.
+func (cfg *config) fewShot(ctx context.Context, embedder rag.Embedder) (rag.FewShot, error) {
+	examples, err := rag.LoadExamples(cfg.examples)
+	if err != nil {
+		return rag.FewShot{}, fmt.Errorf("loading examples: %w", err)
+	}
+
+	embedded, err := examples.Embed(ctx, embedder)
+	if err != nil {
+		return rag.FewShot{}, fmt.Errorf("embedding examples: %w", err)
+	}
+	if embedded > 0 {
+		if err := examples.Save(cfg.examples); err != nil {
+			return rag.FewShot{}, fmt.Errorf("saving examples: %w", err)
+		}
+	}
+
+	f := rag.FewShot{
+		Embedder: embedder,
+		Examples: examples,
+		K:        cfg.shots,
+		Budget:   cfg.shotTokens,
+	}
+
+	return f, nil
+}
+
+// promptID returns the name and version of a prompt of the set, like
+// qa.v2 for qa.
+func promptID(prompts *prompt.Set, name string) string {
+	p, err := prompts.Get(name)
+	if err != nil {
+		return name
+	}
+	return p.ID()
+}
+
+// stringsFlag is a flag that can be given multiple times.
+type stringsFlag []string
+
+// String implements the flag.Value interface.
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set implements the flag.Value interface.
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// runSearch prints the chunks of the index most similar to a query, which
+// can refer to an image.
+func runSearch(ctx context.Context, args []string) error {
+	var cfg config
+
+	fs := newFlagSet("search", "<query>", &cfg)
+	fs.Parse(args)
+
+	query := strings.Join(fs.Args(), " ")
+	if query == "" {
+		fs.Usage()
+		return errors.New("missing query")
+	}
+
+	r, err := cfg.rag(ctx)
+	if err != nil {
+		return err
+	}
+
+	results, err := r.Search(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	for i, result := range results {
+		fmt.Printf("%d. %s (similarity %.3f)\n\n%s\n\n", i+1, result.Chunk.Source, result.Similarity, result.Chunk.Chunk)
+	}
+
+	return nil
+}
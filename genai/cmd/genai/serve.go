@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
+)
+
+// runServe serves search and ask over HTTP:
+//
+//	POST /search {"query": "..."}    -> {"results": [...]}
+//	POST /ask    {"question": "..."} -> {"answer": "...", "results": [...]}
+func runServe(ctx context.Context, args []string) error {
+	var cfg config
+
+	fs := newFlagSet("serve", "", &cfg)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	fs.Parse(args)
+
+	r, err := cfg.rag(ctx)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /search", searchHandler(r))
+	mux.HandleFunc("POST /ask", askHandler(r))
+	mux.HandleFunc("GET /usage", usageHandler(cfg.meter))
+
+	srv := http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Shut the server down when the context is canceled.
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving on http://%s", *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// result is a search result in a response.
+type result struct {
+	Id         int      `json:"id"`
+	Chunk      string   `json:"chunk"`
+	Source     string   `json:"source"`
+	Sources    []string `json:"sources"`
+	Type       string   `json:"type"`
+	ImageURL   string   `json:"image_url,omitempty"`
+	Similarity float64  `json:"similarity"`
+}
+
+// toResults converts search results for a response, leaving out vectors.
+func toResults(results []rag.Result) []result {
+	out := make([]result, len(results))
+	for i, r := range results {
+		out[i] = result{
+			Id:         r.Chunk.Id,
+			Chunk:      r.Chunk.Chunk,
+			Source:     r.Chunk.Source,
+			Sources:    r.Chunk.Sources,
+			Type:       r.Chunk.Type,
+			ImageURL:   r.Chunk.ImageURL,
+			Similarity: r.Similarity,
+		}
+	}
+	return out
+}
+
+func searchHandler(r rag.RAG) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Query string `json:"query"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Query == "" {
+			writeError(w, http.StatusBadRequest, errors.New("body must be {\"query\": \"...\"}"))
+			return
+		}
+
+		results, err := r.Search(req.Context(), body.Query)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{"results": toResults(results)})
+	}
+}
+
+func askHandler(r rag.RAG) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Question string `json:"question"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Question == "" {
+			writeError(w, http.StatusBadRequest, errors.New("body must be {\"question\": \"...\"}"))
+			return
+		}
+
+		ctx, records := llm.WithRecords(req.Context())
+
+		resp, err := r.Ask(ctx, body.Question, nil, io.Discard)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+
+		out := map[string]any{
+			"answer":        resp.Answer,
+			"finish_reason": resp.FinishReason,
+			"results":       toResults(resp.Results),
+		}
+		if len(resp.Attempts) > 1 {
+			out["attempts"] = resp.Attempts
+		}
+		for _, record := range records.List() {
+			if record.Operation == llm.OpChat {
+				out["served_by"] = record.Backend
+			}
+		}
+
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// modelUsage is the usage of a model in a response.
+type modelUsage struct {
+	Model            string `json:"model"`
+	Calls            int    `json:"calls"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+func usageHandler(meter *llm.Meter) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		report := meter.Report()
+
+		models := make([]modelUsage, len(report.Models))
+		for i, mu := range report.Models {
+			models[i] = modelUsage{
+				Model:            mu.Model,
+				Calls:            mu.Calls,
+				PromptTokens:     mu.Usage.PromptTokens,
+				CompletionTokens: mu.Usage.CompletionTokens,
+			}
+		}
+
+		resp := map[string]any{
+			"models":    models,
+			"estimated": report.Total.Estimated,
+		}
+		if report.Priced {
+			resp["cost"] = report.Cost
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
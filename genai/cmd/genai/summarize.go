@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dwhitena/go-genai-webinar/genai/chain"
+	"github.com/dwhitena/go-genai-webinar/genai/longdoc"
+)
+
+// runSummarize summarizes a document too long for a single prompt, or
+// answers a question about it.
+func runSummarize(ctx context.Context, args []string) error {
+	var cfg config
+
+	fs := newFlagSet("summarize", "<file>", &cfg)
+	question := fs.String("question", "", "question to answer from the document instead of summarizing it")
+	mode := fs.String("mode", "map-reduce", "how the pieces of the document are combined: map-reduce, refine, or auto to refine a document of a few pieces and map-reduce a longer one")
+	budget := fs.Int("budget", 0, "maximum number of tokens of a prompt (default the context length of the model less -max-tokens)")
+	parallel := fs.Int("parallel", 4, "maximum number of pieces mapped at once")
+	trace := fs.Bool("trace", false, "write the steps of the chain, with the time they took, to stderr")
+	mapPrompt := fs.String("map-prompt", longdoc.MapPrompt, "name of the prompt of the notes on a piece, with an optional version")
+	reducePrompt := fs.String("reduce-prompt", longdoc.ReducePrompt, "name of the prompt that combines the notes, with an optional version")
+	refinePrompt := fs.String("refine-prompt", longdoc.RefinePrompt, "name of the prompt that refines the answer with a piece, with an optional version")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing file")
+	}
+	if *mode != "map-reduce" && *mode != "refine" && *mode != "auto" {
+		return fmt.Errorf("unknown mode %q, expected map-reduce, refine or auto", *mode)
+	}
+
+	document, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// The document is the context, not the index.
+	cfg.optionalIndex = true
+	r, err := cfg.rag(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{*mapPrompt, *reducePrompt, *refinePrompt} {
+		if _, err := r.Prompts.Get(name); err != nil {
+			return err
+		}
+	}
+
+	c := longdoc.Chain{
+		Client:      r.Client,
+		Model:       r.Model,
+		Prompts:     r.Prompts,
+		Mapping:     *mapPrompt,
+		Reduction:   *reducePrompt,
+		Refinement:  *refinePrompt,
+		Budget:      *budget,
+		MaxTokens:   r.MaxTokens,
+		Temperature: r.Temperature,
+		Parallel:    *parallel,
+		Progress:    os.Stderr,
+	}
+
+	if *trace {
+		ctx = chain.WithHooks(ctx, chain.Trace(os.Stderr))
+	}
+
+	var result longdoc.Result
+	switch *mode {
+	case "refine":
+		result, err = c.Refine(ctx, string(document), *question)
+	case "auto":
+		result, err = c.Summarize(*question)(ctx, string(document))
+	default:
+		result, err = c.MapReduce(ctx, string(document), *question)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(result.Answer)
+	fmt.Printf("\n(%d pieces, %d calls, %s)\n", result.Pieces, result.Calls, result.Usage)
+
+	return nil
+}
@@ -0,0 +1,402 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/predictionguard/go-client"
+)
+
+// Tie is the winner of a verdict in which neither answer is better.
+const Tie = "tie"
+
+// Variant is a way to answer the items, like a version of the prompts.
+type Variant struct {
+	Name   string
+	Answer Answerer
+}
+
+// Preference decides which of two answers to an item is better: 0 for a,
+// 1 for b and -1 for a tie, with the reason.
+type Preference func(ctx context.Context, item Item, a string, b string) (int, string, error)
+
+// preference is what a judge decides about two answers.
+type preference struct {
+	Reason string `json:"reason" description:"why one answer is better than the other, or neither"`
+	Winner string `json:"winner" enum:"A,B,tie" description:"the better answer"`
+}
+
+// PairwiseJudge decides which of two answers is better with a chat model
+// and the pairwise prompt of the set.
+func PairwiseJudge(cln llm.Client, spec llm.ModelSpec, prompts *prompt.Set) Preference {
+	return func(ctx context.Context, item Item, a string, b string) (int, string, error) {
+		content, err := prompts.Render("pairwise", map[string]any{
+			"question": item.Question,
+			"expected": item.Expected,
+			"a":        a,
+			"b":        b,
+		})
+		if err != nil {
+			return 0, "", err
+		}
+
+		messages := []client.ChatInputMessage{
+			{Role: client.Roles.User, Content: content},
+		}
+		p, err := llm.Generate[preference](ctx, cln, spec, messages, 1)
+		if err != nil {
+			return 0, "", err
+		}
+
+		switch p.Winner {
+		case "A":
+			return 0, p.Reason, nil
+		case "B":
+			return 1, p.Reason, nil
+		}
+		return -1, p.Reason, nil
+	}
+}
+
+// Verdict is the judgement of the answers of two variants to an item.
+type Verdict struct {
+	Item   string `json:"item"`
+	A      string `json:"a"`
+	B      string `json:"b"`
+	Winner string `json:"winner"`
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Comparer runs the variants over the same items, scores their answers
+// with the metrics, and has the judge decide between the answers of every
+// pair of variants, at most Parallel at once.
+type Comparer struct {
+	Variants []Variant
+	Metrics  []Metric
+	Judge    Preference
+	Parallel int
+}
+
+// Comparison is the outcome of comparing variants: a report per variant
+// and the verdicts of the judge. The WinRate of a variant is the share of
+// its Judged comparisons it won, a tie counting as half.
+type Comparison struct {
+	Config   map[string]string `json:"config"`
+	Items    []Item            `json:"items"`
+	Variants []string          `json:"variants"`
+	Reports  []Report          `json:"reports"`
+	Verdicts []Verdict         `json:"verdicts"`
+	WinRates []float64         `json:"win_rates"`
+	Judged   []int             `json:"judged"`
+}
+
+// Run compares the variants over the items.
+func (c Comparer) Run(ctx context.Context, items []Item) Comparison {
+	cmp := Comparison{
+		Config: map[string]string{},
+		Items:  items,
+	}
+
+	for _, v := range c.Variants {
+		runner := Runner{Answer: v.Answer, Metrics: c.Metrics, Parallel: c.Parallel}
+		cmp.Variants = append(cmp.Variants, v.Name)
+		cmp.Reports = append(cmp.Reports, runner.Run(ctx, items))
+	}
+
+	if c.Judge == nil {
+		return cmp
+	}
+
+	// Judge every pair of variants on every item. The order the answers
+	// are shown in alternates, so a judge that favors a position favors
+	// each variant as often.
+	type pair struct{ item, a, b int }
+	var pairs []pair
+	for i := range items {
+		for a := range c.Variants {
+			for b := a + 1; b < len(c.Variants); b++ {
+				pairs = append(pairs, pair{i, a, b})
+			}
+		}
+	}
+
+	verdicts := make([]Verdict, len(pairs))
+	forEach(ctx, len(pairs), c.Parallel, func(n int) {
+		p := pairs[n]
+		ra, rb := cmp.Reports[p.a].Results[p.item], cmp.Reports[p.b].Results[p.item]
+		v := Verdict{Item: items[p.item].ID, A: cmp.Variants[p.a], B: cmp.Variants[p.b]}
+		defer func() { verdicts[n] = v }()
+
+		if ra.Error != "" || rb.Error != "" {
+			v.Error = "not answered by both variants"
+			return
+		}
+
+		first, second, swapped := ra.Answer, rb.Answer, n%2 == 1
+		if swapped {
+			first, second = second, first
+		}
+		winner, reason, err := c.Judge(ctx, items[p.item], first, second)
+		if err != nil {
+			v.Error = err.Error()
+			return
+		}
+		if swapped && winner >= 0 {
+			winner = 1 - winner
+		}
+
+		v.Reason = reason
+		switch winner {
+		case 0:
+			v.Winner = v.A
+		case 1:
+			v.Winner = v.B
+		default:
+			v.Winner = Tie
+		}
+	})
+	cmp.Verdicts = verdicts
+
+	wins := make([]float64, len(c.Variants))
+	judged := make([]int, len(c.Variants))
+	index := map[string]int{}
+	for i, name := range cmp.Variants {
+		index[name] = i
+	}
+	for _, v := range verdicts {
+		if v.Error != "" {
+			continue
+		}
+		a, b := index[v.A], index[v.B]
+		judged[a]++
+		judged[b]++
+		switch v.Winner {
+		case v.A:
+			wins[a]++
+		case v.B:
+			wins[b]++
+		default:
+			wins[a] += 0.5
+			wins[b] += 0.5
+		}
+	}
+	cmp.Judged = judged
+	cmp.WinRates = make([]float64, len(c.Variants))
+	for i := range wins {
+		if judged[i] > 0 {
+			cmp.WinRates[i] = wins[i] / float64(judged[i])
+		}
+	}
+
+	return cmp
+}
+
+// row is a variant in the summary of a comparison.
+type row struct {
+	Name         string
+	WinRate      string
+	Scores       []string
+	Latency      string
+	LatencyDelta string
+	Tokens       int
+	TokensDelta  string
+	Errors       int
+}
+
+// itemView is an item in a comparison, with the answers of the variants.
+type itemView struct {
+	ID       string
+	Question string
+	Expected string
+	Answers  []answerView
+	Verdicts []Verdict
+}
+
+// answerView is the answer of a variant to an item.
+type answerView struct {
+	Variant string
+	Answer  string
+	Scores  string
+	Latency string
+}
+
+// view prepares a comparison for the markdown and HTML reports. The
+// latency and tokens of the variants are compared to the first one.
+func (c Comparison) view() (metrics []string, rows []row, items []itemView) {
+	if len(c.Reports) > 0 {
+		for _, s := range c.Reports[0].Metrics {
+			metrics = append(metrics, s.Metric)
+		}
+	}
+
+	for i, report := range c.Reports {
+		r := row{
+			Name:    c.Variants[i],
+			WinRate: "-",
+			Latency: report.MeanLatency.Round(time.Millisecond).String(),
+			Tokens:  report.PromptTokens + report.CompletionTokens,
+			Errors:  report.Errors,
+		}
+		if i < len(c.Judged) && c.Judged[i] > 0 {
+			r.WinRate = fmt.Sprintf("%.0f%%", c.WinRates[i]*100)
+		}
+		for _, name := range metrics {
+			s, _ := report.Metric(name)
+			r.Scores = append(r.Scores, fmt.Sprintf("%.3f", s.Mean))
+		}
+		if i > 0 {
+			base := c.Reports[0]
+			delta := (report.MeanLatency - base.MeanLatency).Round(time.Millisecond)
+			r.LatencyDelta = delta.String()
+			if delta >= 0 {
+				r.LatencyDelta = "+" + r.LatencyDelta
+			}
+			r.TokensDelta = fmt.Sprintf("%+d", r.Tokens-base.PromptTokens-base.CompletionTokens)
+		}
+		rows = append(rows, r)
+	}
+
+	for i, item := range c.Items {
+		iv := itemView{ID: item.ID, Question: item.Question, Expected: item.Expected}
+		for v, report := range c.Reports {
+			result := report.Results[i]
+			a := answerView{
+				Variant: c.Variants[v],
+				Answer:  result.Answer,
+				Latency: result.Latency.Round(time.Millisecond).String(),
+			}
+			if result.Error != "" {
+				a.Answer = "ERROR: " + result.Error
+			}
+			var scores []string
+			for _, name := range metrics {
+				if score, exists := result.Scores[name]; exists {
+					scores = append(scores, fmt.Sprintf("%s %.2f", name, score))
+				}
+			}
+			a.Scores = strings.Join(scores, ", ")
+			iv.Answers = append(iv.Answers, a)
+		}
+		for _, v := range c.Verdicts {
+			if v.Item == item.ID {
+				iv.Verdicts = append(iv.Verdicts, v)
+			}
+		}
+		items = append(items, iv)
+	}
+
+	return metrics, rows, items
+}
+
+// WriteMarkdown writes the comparison as a markdown report: a summary of
+// the variants, then the answers to each item side by side.
+func (c Comparison) WriteMarkdown(w io.Writer) error {
+	metrics, rows, items := c.view()
+
+	var b strings.Builder
+	b.WriteString("# Prompt comparison\n\n")
+	for _, key := range slices.Sorted(maps.Keys(c.Config)) {
+		fmt.Fprintf(&b, "- %s: %s\n", key, c.Config[key])
+	}
+
+	b.WriteString("\n| Variant | Win rate |")
+	for _, name := range metrics {
+		fmt.Fprintf(&b, " %s |", name)
+	}
+	b.WriteString(" Latency | Δ latency | Tokens | Δ tokens | Errors |\n|---|---|")
+	for range metrics {
+		b.WriteString("---|")
+	}
+	b.WriteString("---|---|---|---|---|\n")
+	for _, r := range rows {
+		fmt.Fprintf(&b, "| %s | %s |", cell(r.Name), r.WinRate)
+		for _, score := range r.Scores {
+			fmt.Fprintf(&b, " %s |", score)
+		}
+		fmt.Fprintf(&b, " %s | %s | %d | %s | %d |\n", r.Latency, r.LatencyDelta, r.Tokens, r.TokensDelta, r.Errors)
+	}
+
+	for _, item := range items {
+		fmt.Fprintf(&b, "\n## %s: %s\n\n", item.ID, cell(item.Question))
+		if item.Expected != "" {
+			fmt.Fprintf(&b, "Expected: %s\n\n", cell(item.Expected))
+		}
+		b.WriteString("| Variant | Answer | Scores | Latency |\n|---|---|---|---|\n")
+		for _, a := range item.Answers {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", cell(a.Variant), cell(a.Answer), a.Scores, a.Latency)
+		}
+		if len(item.Verdicts) > 0 {
+			b.WriteString("\n")
+		}
+		for _, v := range item.Verdicts {
+			if v.Error != "" {
+				fmt.Fprintf(&b, "- %s vs %s: not judged, %s\n", v.A, v.B, v.Error)
+				continue
+			}
+			fmt.Fprintf(&b, "- %s vs %s: **%s**, %s\n", v.A, v.B, v.Winner, cell(v.Reason))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// cell makes a text fit in a markdown table cell.
+func cell(text string) string {
+	text = strings.ReplaceAll(text, "|", `\|`)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// htmlReport is the template of the HTML report.
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Prompt comparison</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 0.4em 0.6em; text-align: left; vertical-align: top; }
+td.answer { white-space: pre-wrap; max-width: 40em; }
+</style>
+</head>
+<body>
+<h1>Prompt comparison</h1>
+<ul>{{range $key, $value := .Config}}<li>{{$key}}: {{$value}}</li>{{end}}</ul>
+<table>
+<tr><th>Variant</th><th>Win rate</th>{{range .Metrics}}<th>{{.}}</th>{{end}}<th>Latency</th><th>Δ latency</th><th>Tokens</th><th>Δ tokens</th><th>Errors</th></tr>
+{{range .Rows}}<tr><td>{{.Name}}</td><td>{{.WinRate}}</td>{{range .Scores}}<td>{{.}}</td>{{end}}<td>{{.Latency}}</td><td>{{.LatencyDelta}}</td><td>{{.Tokens}}</td><td>{{.TokensDelta}}</td><td>{{.Errors}}</td></tr>
+{{end}}</table>
+{{range .Items}}
+<h2>{{.ID}}: {{.Question}}</h2>
+{{if .Expected}}<p>Expected: {{.Expected}}</p>{{end}}
+<table>
+<tr><th>Variant</th><th>Answer</th><th>Scores</th><th>Latency</th></tr>
+{{range .Answers}}<tr><td>{{.Variant}}</td><td class="answer">{{.Answer}}</td><td>{{.Scores}}</td><td>{{.Latency}}</td></tr>
+{{end}}</table>
+{{if .Verdicts}}<ul>{{range .Verdicts}}<li>{{.A}} vs {{.B}}: {{if .Error}}not judged, {{.Error}}{{else}}<strong>{{.Winner}}</strong>, {{.Reason}}{{end}}</li>{{end}}</ul>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes the comparison as an HTML page, laid out like the
+// markdown report.
+func (c Comparison) WriteHTML(w io.Writer) error {
+	metrics, rows, items := c.view()
+	return htmlReport.Execute(w, map[string]any{
+		"Config":  c.Config,
+		"Metrics": metrics,
+		"Rows":    rows,
+		"Items":   items,
+	})
+}
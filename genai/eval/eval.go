@@ -0,0 +1,185 @@
+// Package eval scores the answers of a prompt and a model to a dataset of
+// questions with expected answers, so prompt changes can be compared by
+// their metrics instead of by eye.
+package eval
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/predictionguard/go-client"
+)
+
+// Item is a question of a dataset with the answer expected for it. The
+// Context is what the question is answered from, retrieved from an index
+// if empty. The Pattern is a regular expression the answer should match,
+// for the regex metric.
+type Item struct {
+	ID       string `json:"id"`
+	Question string `json:"question"`
+	Context  string `json:"context,omitempty"`
+	Expected string `json:"expected"`
+	Pattern  string `json:"pattern,omitempty"`
+}
+
+// LoadDataset loads the items of a JSONL file, one item per line. Items
+// without an ID are numbered by line.
+func LoadDataset(path string) ([]Item, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var items []Item
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var item Item
+		if err := json.Unmarshal([]byte(text), &item); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if item.Question == "" {
+			return nil, fmt.Errorf("%s:%d: missing question", path, line)
+		}
+		if item.Pattern != "" {
+			if _, err := regexp.Compile(item.Pattern); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+		}
+		if item.ID == "" {
+			item.ID = fmt.Sprint(line)
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Scorer scores an answer to an item from 0 to 1. A scorer that does not
+// apply to an item, like regex to an item without a pattern, returns
+// false.
+type Scorer func(ctx context.Context, item Item, answer string) (float64, bool, error)
+
+// Metric is a named scorer.
+type Metric struct {
+	Name  string
+	Score Scorer
+}
+
+// ExactMatch scores 1 when the answer is the expected answer, ignoring
+// case and surrounding whitespace.
+func ExactMatch() Metric {
+	return Metric{"exact", func(ctx context.Context, item Item, answer string) (float64, bool, error) {
+		if item.Expected == "" {
+			return 0, false, nil
+		}
+		return boolScore(strings.EqualFold(strings.TrimSpace(answer), strings.TrimSpace(item.Expected))), true, nil
+	}}
+}
+
+// Contains scores 1 when the answer contains the expected answer, with
+// case, punctuation and spacing ignored.
+func Contains() Metric {
+	return Metric{"contains", func(ctx context.Context, item Item, answer string) (float64, bool, error) {
+		if item.Expected == "" {
+			return 0, false, nil
+		}
+		return boolScore(strings.Contains(llm.NormalizeAnswer(answer), llm.NormalizeAnswer(item.Expected))), true, nil
+	}}
+}
+
+// Regex scores 1 when the answer matches the pattern of the item.
+func Regex() Metric {
+	return Metric{"regex", func(ctx context.Context, item Item, answer string) (float64, bool, error) {
+		if item.Pattern == "" {
+			return 0, false, nil
+		}
+		re, err := regexp.Compile(item.Pattern)
+		if err != nil {
+			return 0, false, err
+		}
+		return boolScore(re.MatchString(answer)), true, nil
+	}}
+}
+
+// verdict is what a judge decides about an answer.
+type verdict struct {
+	Reason  string `json:"reason" description:"why the answer does or does not say the same as the expected answer"`
+	Correct bool   `json:"correct" description:"whether the answer says the same as the expected answer"`
+}
+
+// Judge scores 1 when a chat model judges that the answer says the same
+// as the expected answer, with the judge prompt of the set.
+func Judge(cln llm.Client, spec llm.ModelSpec, prompts *prompt.Set) Metric {
+	return Metric{"judge", func(ctx context.Context, item Item, answer string) (float64, bool, error) {
+		if item.Expected == "" {
+			return 0, false, nil
+		}
+
+		content, err := prompts.Render("judge", map[string]any{
+			"question": item.Question,
+			"expected": item.Expected,
+			"answer":   answer,
+		})
+		if err != nil {
+			return 0, false, err
+		}
+
+		messages := []client.ChatInputMessage{
+			{Role: client.Roles.User, Content: content},
+		}
+		v, err := llm.Generate[verdict](ctx, cln, spec, messages, 1)
+		if err != nil {
+			return 0, false, err
+		}
+
+		return boolScore(v.Correct), true, nil
+	}}
+}
+
+// Metrics returns the metrics by name: exact, contains, regex and judge.
+// The judge is only available with a client.
+func Metrics(names []string, cln llm.Client, spec llm.ModelSpec, prompts *prompt.Set) ([]Metric, error) {
+	var metrics []Metric
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "exact":
+			metrics = append(metrics, ExactMatch())
+		case "contains":
+			metrics = append(metrics, Contains())
+		case "regex":
+			metrics = append(metrics, Regex())
+		case "judge":
+			if cln == nil {
+				return nil, fmt.Errorf("metric judge needs a client")
+			}
+			metrics = append(metrics, Judge(cln, spec, prompts))
+		default:
+			return nil, fmt.Errorf("unknown metric %q, expected exact, contains, regex or judge", name)
+		}
+	}
+	return metrics, nil
+}
+
+func boolScore(ok bool) float64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,301 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+)
+
+// Answer is the answer of the prompt and model under evaluation to an
+// item.
+type Answer struct {
+	Text  string
+	Usage llm.Usage
+}
+
+// Answerer answers an item with the prompt and model under evaluation.
+type Answerer func(ctx context.Context, item Item) (Answer, error)
+
+// Runner answers the items of a dataset, at most Parallel at once, and
+// scores the answers with the metrics.
+type Runner struct {
+	Answer   Answerer
+	Metrics  []Metric
+	Parallel int
+}
+
+// Result is the answer to an item and its scores by metric, with the
+// metrics that do not apply to the item left out. The Error is why the
+// item could not be answered, and ScoreErrors why metrics could not
+// score the answer.
+type Result struct {
+	ID               string             `json:"id"`
+	Question         string             `json:"question"`
+	Expected         string             `json:"expected,omitempty"`
+	Answer           string             `json:"answer"`
+	Scores           map[string]float64 `json:"scores"`
+	Latency          time.Duration      `json:"latency"`
+	PromptTokens     int                `json:"prompt_tokens"`
+	CompletionTokens int                `json:"completion_tokens"`
+	Error            string             `json:"error,omitempty"`
+	ScoreErrors      map[string]string  `json:"score_errors,omitempty"`
+}
+
+// Summary is the mean score of a metric over the items it applies to.
+type Summary struct {
+	Metric string  `json:"metric"`
+	Mean   float64 `json:"mean"`
+	Count  int     `json:"count"`
+}
+
+// Report is the outcome of a run. The Config records what was evaluated,
+// like the model and the prompt versions, to tell reports apart.
+type Report struct {
+	Config           map[string]string `json:"config"`
+	Results          []Result          `json:"results"`
+	Metrics          []Summary         `json:"metrics"`
+	Errors           int               `json:"errors"`
+	MeanLatency      time.Duration     `json:"mean_latency"`
+	PromptTokens     int               `json:"prompt_tokens"`
+	CompletionTokens int               `json:"completion_tokens"`
+}
+
+// Run answers and scores the items. Items that fail are reported with
+// their error and left out of the metrics.
+func (r Runner) Run(ctx context.Context, items []Item) Report {
+	// Items left out when the context is done are reported as canceled.
+	results := make([]Result, len(items))
+	for i, item := range items {
+		results[i] = Result{ID: item.ID, Question: item.Question, Expected: item.Expected, Error: context.Canceled.Error()}
+	}
+	forEach(ctx, len(items), r.Parallel, func(i int) {
+		results[i] = r.run(ctx, items[i])
+	})
+
+	return summarize(results, r.Metrics)
+}
+
+// forEach calls fn for 0 to n-1, at most parallel at once, and returns
+// once all calls returned. Calls that have not started when the context
+// is done are skipped.
+func forEach(ctx context.Context, n int, parallel int, fn func(i int)) {
+	sem := make(chan struct{}, max(parallel, 1))
+	var wg sync.WaitGroup
+	for i := range n {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// run answers and scores an item.
+func (r Runner) run(ctx context.Context, item Item) Result {
+	result := Result{
+		ID:       item.ID,
+		Question: item.Question,
+		Expected: item.Expected,
+		Scores:   map[string]float64{},
+	}
+
+	start := time.Now()
+	answer, err := r.Answer(ctx, item)
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Answer = answer.Text
+	result.PromptTokens = answer.Usage.PromptTokens
+	result.CompletionTokens = answer.Usage.CompletionTokens
+
+	for _, metric := range r.Metrics {
+		score, ok, err := metric.Score(ctx, item, answer.Text)
+		if err != nil {
+			if result.ScoreErrors == nil {
+				result.ScoreErrors = map[string]string{}
+			}
+			result.ScoreErrors[metric.Name] = err.Error()
+			continue
+		}
+		if ok {
+			result.Scores[metric.Name] = score
+		}
+	}
+
+	return result
+}
+
+// summarize aggregates the results of a run.
+func summarize(results []Result, metrics []Metric) Report {
+	report := Report{Config: map[string]string{}, Results: results}
+
+	var latency time.Duration
+	for _, result := range results {
+		if result.Error != "" {
+			report.Errors++
+			continue
+		}
+		latency += result.Latency
+		report.PromptTokens += result.PromptTokens
+		report.CompletionTokens += result.CompletionTokens
+	}
+	if answered := len(results) - report.Errors; answered > 0 {
+		report.MeanLatency = latency / time.Duration(answered)
+	}
+
+	for _, metric := range metrics {
+		s := Summary{Metric: metric.Name}
+		var total float64
+		for _, result := range results {
+			if score, exists := result.Scores[metric.Name]; exists {
+				total += score
+				s.Count++
+			}
+		}
+		if s.Count > 0 {
+			s.Mean = total / float64(s.Count)
+		}
+		report.Metrics = append(report.Metrics, s)
+	}
+
+	return report
+}
+
+// LoadReport loads a report saved by Save.
+func LoadReport(path string) (Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return Report{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return report, nil
+}
+
+// Save writes the report to a JSON file.
+func (r Report) Save(path string) error {
+	outJSON, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, outJSON, 0644)
+}
+
+// Metric returns the summary of a metric, if the report has it.
+func (r Report) Metric(name string) (Summary, bool) {
+	for _, s := range r.Metrics {
+		if s.Metric == name {
+			return s, true
+		}
+	}
+	return Summary{}, false
+}
+
+// WriteText writes the report as a table of the items, with their scores,
+// followed by the metrics.
+func (r Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprint(tw, "ID")
+	for _, s := range r.Metrics {
+		fmt.Fprintf(tw, "\t%s", s.Metric)
+	}
+	fmt.Fprint(tw, "\tLATENCY\tANSWER\n")
+
+	for _, result := range r.Results {
+		fmt.Fprint(tw, result.ID)
+		for _, s := range r.Metrics {
+			switch score, exists := result.Scores[s.Metric]; {
+			case exists:
+				fmt.Fprintf(tw, "\t%.2f", score)
+			case result.ScoreErrors[s.Metric] != "":
+				fmt.Fprint(tw, "\terror")
+			default:
+				fmt.Fprint(tw, "\t-")
+			}
+		}
+		answer := result.Answer
+		if result.Error != "" {
+			answer = "ERROR: " + result.Error
+		}
+		fmt.Fprintf(tw, "\t%s\t%s\n", result.Latency.Round(time.Millisecond), truncate(answer, 60))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, result := range r.Results {
+		for _, s := range r.Metrics {
+			if err, exists := result.ScoreErrors[s.Metric]; exists {
+				fmt.Fprintf(w, "\n%s: %s: %s", result.ID, s.Metric, err)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d items, %d errors, mean latency %s, %d prompt + %d completion tokens (estimated)\n\n",
+		len(r.Results), r.Errors, r.MeanLatency.Round(time.Millisecond), r.PromptTokens, r.CompletionTokens)
+
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprint(tw, "METRIC\tMEAN\tITEMS\n")
+	for _, s := range r.Metrics {
+		fmt.Fprintf(tw, "%s\t%.3f\t%d\n", s.Metric, s.Mean, s.Count)
+	}
+	return tw.Flush()
+}
+
+// Compare writes how the metrics, latency and tokens of a report changed
+// from a baseline report.
+func Compare(w io.Writer, baseline Report, report Report) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprint(tw, "METRIC\tBASELINE\tTHIS RUN\tDELTA\n")
+	for _, s := range report.Metrics {
+		base, ok := baseline.Metric(s.Metric)
+		if !ok {
+			fmt.Fprintf(tw, "%s\t-\t%.3f\t-\n", s.Metric, s.Mean)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%+.3f\n", s.Metric, base.Mean, s.Mean, s.Mean-base.Mean)
+	}
+	delta := (report.MeanLatency - baseline.MeanLatency).Round(time.Millisecond)
+	sign := ""
+	if delta >= 0 {
+		sign = "+"
+	}
+	fmt.Fprintf(tw, "latency\t%s\t%s\t%s%s\n",
+		baseline.MeanLatency.Round(time.Millisecond), report.MeanLatency.Round(time.Millisecond), sign, delta)
+	fmt.Fprintf(tw, "tokens\t%d\t%d\t%+d\n",
+		baseline.PromptTokens+baseline.CompletionTokens, report.PromptTokens+report.CompletionTokens,
+		report.PromptTokens+report.CompletionTokens-baseline.PromptTokens-baseline.CompletionTokens)
+	return tw.Flush()
+}
+
+// truncate shortens a text to n runes on a single line.
+func truncate(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > n {
+		return string(runes[:n-1]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,14 @@
+module github.com/dwhitena/go-genai-webinar/genai
+
+go 1.23
+
+require (
+	github.com/JohannesKaufmann/html-to-markdown v1.6.0
+	github.com/PuerkitoBio/goquery v1.9.2
+	github.com/predictionguard/go-client v0.13.0
+)
+
+require (
+	github.com/andybalholm/cascadia v1.3.2 // indirect
+	golang.org/x/net v0.25.0 // indirect
+)
@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Cache stores responses by key. Implementations are safe for concurrent
+// use.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte) error
+}
+
+// LRU is an in-memory Cache that evicts the least recently used entries
+// once it holds its capacity.
+type LRU struct {
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// lruEntry is an entry of an LRU cache.
+type lruEntry struct {
+	key   string
+	value []byte
+}
+
+// NewLRU constructs an LRU cache that holds up to capacity entries.
+func NewLRU(capacity int) *LRU {
+	return &LRU{
+		capacity: max(capacity, 1),
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get implements the Cache interface.
+func (c *LRU) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*lruEntry).value, true
+}
+
+// Set implements the Cache interface.
+func (c *LRU) Set(key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		elem.Value.(*lruEntry).value = value
+		c.order.MoveToFront(elem)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+
+	return nil
+}
+
+// DiskCache is a Cache that keeps each entry in a file of a directory, so
+// it outlives the process.
+type DiskCache struct {
+	Dir string
+}
+
+// path returns the file of an entry, spread over subdirectories so none
+// grows too large.
+func (c DiskCache) path(key string) string {
+	if len(key) < 2 {
+		return filepath.Join(c.Dir, key)
+	}
+	return filepath.Join(c.Dir, key[:2], key)
+}
+
+// Get implements the Cache interface.
+func (c DiskCache) Get(key string) ([]byte, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Set implements the Cache interface. The entry is written to a temporary
+// file first, so a reader never sees it half written.
+func (c DiskCache) Set(key string, value []byte) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(value); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
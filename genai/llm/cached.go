@@ -0,0 +1,267 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/predictionguard/go-client"
+)
+
+// Cached is a Client that serves repeated calls from a Cache. Calls are
+// keyed by model, parameters, input and sample (see WithSample), with
+// whitespace normalized, and responses expire after the TTL, if any. With
+// Bypass set, responses are not read from the cache but still stored, to
+// refresh it.
+//
+// A streamed chat is only stored once it finishes, and a hit is replayed
+// as a single message.
+type Cached struct {
+	Client Client
+	Cache  Cache
+	TTL    time.Duration
+	Bypass bool
+}
+
+// cacheEntry is a response in the cache.
+type cacheEntry struct {
+	Expires time.Time       `json:"expires"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// cacheMessage is a chat message in the key of a call.
+type cacheMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// cacheChat is the key of a chat call.
+type cacheChat struct {
+	Model       string         `json:"model"`
+	Messages    []cacheMessage `json:"messages"`
+	MaxTokens   int            `json:"max_tokens"`
+	Temperature float32        `json:"temperature"`
+	TopP        float64        `json:"top_p"`
+	TopK        float64        `json:"top_k"`
+	Sample      int            `json:"sample,omitempty"`
+}
+
+// Completions implements the Client interface.
+func (c *Cached) Completions(ctx context.Context, input client.CompletionInput) (client.Completion, error) {
+	key := cacheKey("completions", cacheChat{
+		Model:       input.Model.String(),
+		Messages:    []cacheMessage{{Content: normalize(input.Prompt)}},
+		MaxTokens:   input.MaxTokens,
+		Temperature: input.Temperature,
+		TopP:        input.TopP,
+		TopK:        input.TopK,
+		Sample:      sampleOf(ctx),
+	})
+
+	return cached(c, key, func() (client.Completion, error) {
+		return c.Client.Completions(ctx, input)
+	})
+}
+
+// Chat implements the Client interface.
+func (c *Cached) Chat(ctx context.Context, input client.ChatInput) (client.Chat, error) {
+	key := cacheKey("chat", cacheChat{
+		Model:       input.Model.String(),
+		Messages:    cacheMessages(input.Messages),
+		MaxTokens:   input.MaxTokens,
+		Temperature: input.Temperature,
+		TopP:        input.TopP,
+		TopK:        input.TopK,
+		Sample:      sampleOf(ctx),
+	})
+
+	return cached(c, key, func() (client.Chat, error) {
+		return c.Client.Chat(ctx, input)
+	})
+}
+
+// ChatSSE implements the Client interface.
+func (c *Cached) ChatSSE(ctx context.Context, input client.ChatSSEInput, ch chan client.ChatSSE) error {
+	key := cacheKey("chat-sse", cacheChat{
+		Model:       input.Model.String(),
+		Messages:    cacheMessages(input.Messages),
+		MaxTokens:   input.MaxTokens,
+		Temperature: input.Temperature,
+		TopP:        input.TopP,
+		TopK:        input.TopK,
+		Sample:      sampleOf(ctx),
+	})
+
+	var hit Message
+	if !c.Bypass && c.get(key, &hit) {
+		go func() {
+			defer close(ch)
+			resp := client.ChatSSE{
+				Model: input.Model,
+				Choices: []client.ChatSSEChoice{
+					{Delta: client.ChatSSEDelta{Content: hit.Content}, FinishReason: hit.FinishReason},
+				},
+			}
+			select {
+			case ch <- resp:
+			case <-ctx.Done():
+			}
+		}()
+		return nil
+	}
+
+	inner := make(chan client.ChatSSE, cap(ch))
+	if err := c.Client.ChatSSE(ctx, input, inner); err != nil {
+		return err
+	}
+
+	go func() {
+		defer close(ch)
+
+		var m Message
+		for resp := range inner {
+			for _, choice := range resp.Choices {
+				m.Content += choice.Delta.Content
+				if choice.FinishReason != "" {
+					m.FinishReason = choice.FinishReason
+				}
+			}
+
+			// Keep draining the stream when nobody is reading anymore.
+			select {
+			case ch <- resp:
+			case <-ctx.Done():
+			}
+		}
+
+		if m.FinishReason != "" && ctx.Err() == nil {
+			c.set(key, m)
+		}
+	}()
+
+	return nil
+}
+
+// Embedding implements the Client interface. Images are encoded once, to
+// key the call on their content.
+func (c *Cached) Embedding(ctx context.Context, input []client.EmbeddingInput) (client.Embedding, error) {
+	type cacheEmbedding struct {
+		Text  string `json:"text"`
+		Image string `json:"image"`
+	}
+
+	keyInput := make([]cacheEmbedding, len(input))
+	encoded := make([]client.EmbeddingInput, len(input))
+	for i, in := range input {
+		encoded[i] = in
+		keyInput[i].Text = normalize(in.Text)
+		if in.Image == nil {
+			continue
+		}
+
+		image, err := in.Image.EncodeBase64(ctx)
+		if err != nil {
+			return client.Embedding{}, fmt.Errorf("image: %w", err)
+		}
+		encoded[i].Image = client.NewImageBase64(image)
+		sum := sha256.Sum256([]byte(image))
+		keyInput[i].Image = hex.EncodeToString(sum[:])
+	}
+
+	key := cacheKey("embedding", keyInput)
+
+	return cached(c, key, func() (client.Embedding, error) {
+		return c.Client.Embedding(ctx, encoded)
+	})
+}
+
+// Factuality implements the Client interface.
+func (c *Cached) Factuality(ctx context.Context, reference string, text string) (client.Factuality, error) {
+	key := cacheKey("factuality", []string{normalize(reference), normalize(text)})
+
+	return cached(c, key, func() (client.Factuality, error) {
+		return c.Client.Factuality(ctx, reference, text)
+	})
+}
+
+// get reads a response from the cache into v, and reports if it was there
+// and had not expired.
+func (c *Cached) get(key string, v any) bool {
+	data, exists := c.Cache.Get(key)
+	if !exists {
+		return false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return false
+	}
+	if !entry.Expires.IsZero() && time.Now().After(entry.Expires) {
+		return false
+	}
+
+	return json.Unmarshal(entry.Value, v) == nil
+}
+
+// set stores a response in the cache. A response that cannot be stored
+// only costs a call the next time, so errors are ignored.
+func (c *Cached) set(key string, v any) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	entry := cacheEntry{Value: value}
+	if c.TTL > 0 {
+		entry.Expires = time.Now().Add(c.TTL)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	c.Cache.Set(key, data)
+}
+
+// cached serves a call from the cache, or makes it and stores a successful
+// response.
+func cached[T any](c *Cached, key string, call func() (T, error)) (T, error) {
+	var resp T
+	if !c.Bypass && c.get(key, &resp) {
+		return resp, nil
+	}
+
+	resp, err := call()
+	if err != nil {
+		return resp, err
+	}
+	c.set(key, resp)
+
+	return resp, nil
+}
+
+// cacheKey hashes the kind of a call and its input into a key.
+func cacheKey(kind string, input any) string {
+	data, _ := json.Marshal(input)
+	sum := sha256.Sum256(append([]byte(kind+"\n"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheMessages normalizes chat messages for the key of a call.
+func cacheMessages(messages []client.ChatInputMessage) []cacheMessage {
+	msgs := make([]cacheMessage, len(messages))
+	for i, m := range messages {
+		msgs[i] = cacheMessage{Role: m.Role.String(), Content: normalize(m.Content)}
+	}
+	return msgs
+}
+
+// normalize collapses the whitespace of a text, so inputs that only differ
+// in spacing share a response.
+func normalize(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
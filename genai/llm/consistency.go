@@ -0,0 +1,224 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/predictionguard/go-client"
+)
+
+type sampleKey struct{}
+
+// WithSample returns a context for the n-th sample of a call, so the
+// samples of the same input are cached apart.
+func WithSample(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, sampleKey{}, n)
+}
+
+// sampleOf returns the sample number of a context, 0 if none.
+func sampleOf(ctx context.Context) int {
+	n, _ := ctx.Value(sampleKey{}).(int)
+	return n
+}
+
+// Consensus is the answer the samples of a call agree on. Agreement is
+// the share of the answers that agree with it, from 0 to 1, and Failed
+// the number of samples that returned an error.
+type Consensus struct {
+	Answer    string
+	Agreement float64
+	Answers   []string
+	Failed    int
+}
+
+// Aggregator picks the consensus of the answers to the same messages.
+type Aggregator func(ctx context.Context, messages []client.ChatInputMessage, answers []string) (Consensus, error)
+
+// SelfConsistency samples the answer to a chat several times, concurrently
+// with at most Parallel calls at once, and aggregates the answers, by
+// majority vote of the exact answers if there is no Aggregate. The samples
+// are only diverse at a temperature above zero.
+type SelfConsistency struct {
+	Client    Client
+	Samples   int
+	Parallel  int
+	Aggregate Aggregator
+}
+
+// Chat samples the answer to a chat. Failed samples are left out of the
+// vote, and only when all of them fail is an error returned.
+func (s SelfConsistency) Chat(ctx context.Context, input client.ChatInput) (Consensus, error) {
+	samples := max(s.Samples, 1)
+	parallel := s.Parallel
+	if parallel <= 0 {
+		parallel = samples
+	}
+
+	answers := make([]string, samples)
+	errs := make([]error, samples)
+
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := range samples {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+
+			resp, err := s.Client.Chat(WithSample(ctx, i+1), input)
+			switch {
+			case err != nil:
+				errs[i] = err
+			case len(resp.Choices) == 0:
+				errs[i] = errors.New("no choices in response")
+			default:
+				answers[i] = resp.Choices[0].Message.Content
+			}
+		}()
+	}
+	wg.Wait()
+
+	var ok []string
+	for i, err := range errs {
+		if err == nil {
+			ok = append(ok, answers[i])
+		}
+	}
+	if len(ok) == 0 {
+		return Consensus{}, fmt.Errorf("all %d samples failed: %w", samples, errors.Join(errs...))
+	}
+
+	aggregate := s.Aggregate
+	if aggregate == nil {
+		aggregate = MajorityVote(nil)
+	}
+
+	consensus, err := aggregate(ctx, input.Messages, ok)
+	if err != nil {
+		return Consensus{}, fmt.Errorf("aggregate: %w", err)
+	}
+	consensus.Answers = ok
+	consensus.Failed = samples - len(ok)
+
+	return consensus, nil
+}
+
+// MajorityVote aggregates answers by the most frequent one, with ties won
+// by the first answer given. Answers are compared after the normalize
+// function, if any, so "Paris." and "paris" can count as the same vote.
+func MajorityVote(normalize func(answer string) string) Aggregator {
+	return func(ctx context.Context, messages []client.ChatInputMessage, answers []string) (Consensus, error) {
+		if len(answers) == 0 {
+			return Consensus{}, errors.New("no answers")
+		}
+
+		votes := map[string]int{}
+		first := map[string]int{}
+		for i, answer := range answers {
+			key := answer
+			if normalize != nil {
+				key = normalize(answer)
+			}
+			if _, exists := first[key]; !exists {
+				first[key] = i
+			}
+			votes[key]++
+		}
+
+		var best string
+		for key, n := range votes {
+			if n > votes[best] || (n == votes[best] && first[key] < first[best]) {
+				best = key
+			}
+		}
+
+		return Consensus{
+			Answer:    answers[first[best]],
+			Agreement: float64(votes[best]) / float64(len(answers)),
+		}, nil
+	}
+}
+
+// NormalizeAnswer lowercases an answer and drops its punctuation and
+// extra whitespace, for MajorityVote.
+func NormalizeAnswer(answer string) string {
+	answer = strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, answer)
+	return strings.Join(strings.Fields(answer), " ")
+}
+
+// verdict is what a judge decides about the answers.
+type verdict struct {
+	Answer   int   `json:"answer" description:"the number of the answer that most of the answers agree with"`
+	Agreeing []int `json:"agreeing" description:"the numbers of all the answers that agree with it, including itself"`
+}
+
+// JudgeVote aggregates answers with a chat model that judges which answer
+// most of them agree with in meaning, for free-form answers that a vote
+// cannot compare, with the vote prompt of the set.
+func JudgeVote(cln Client, spec ModelSpec, prompts *prompt.Set) Aggregator {
+	return func(ctx context.Context, messages []client.ChatInputMessage, answers []string) (Consensus, error) {
+		if len(answers) == 0 {
+			return Consensus{}, errors.New("no answers")
+		}
+		if len(answers) == 1 {
+			return Consensus{Answer: answers[0], Agreement: 1}, nil
+		}
+
+		conversation := make([]map[string]any, len(messages))
+		for i, m := range messages {
+			conversation[i] = map[string]any{"role": m.Role.String(), "content": m.Content}
+		}
+		numbered := make([]map[string]any, len(answers))
+		for i, answer := range answers {
+			numbered[i] = map[string]any{"number": i + 1, "text": answer}
+		}
+		content, err := prompts.Render("vote", map[string]any{
+			"messages": conversation,
+			"answers":  numbered,
+		})
+		if err != nil {
+			return Consensus{}, err
+		}
+
+		judge := []client.ChatInputMessage{
+			{Role: client.Roles.User, Content: content},
+		}
+
+		v, err := Generate[verdict](ctx, cln, spec, judge, 1)
+		if err != nil {
+			return Consensus{}, fmt.Errorf("judge: %w", err)
+		}
+		if v.Answer < 1 || v.Answer > len(answers) {
+			return Consensus{}, fmt.Errorf("judge: no answer %d", v.Answer)
+		}
+
+		agreeing := map[int]bool{v.Answer: true}
+		for _, n := range v.Agreeing {
+			if n >= 1 && n <= len(answers) {
+				agreeing[n] = true
+			}
+		}
+
+		return Consensus{
+			Answer:    answers[v.Answer-1],
+			Agreement: float64(len(agreeing)) / float64(len(answers)),
+		}, nil
+	}
+}
@@ -0,0 +1,110 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/llm/llmtest"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/predictionguard/go-client"
+)
+
+func TestMajorityVote(t *testing.T) {
+	tests := []struct {
+		name          string
+		normalize     func(string) string
+		answers       []string
+		wantAnswer    string
+		wantAgreement float64
+	}{
+		{name: "majority", answers: []string{"Lyon", "Paris", "Paris"}, wantAnswer: "Paris", wantAgreement: 2.0 / 3},
+		{name: "tie won by the first", answers: []string{"Lyon", "Paris", "Paris", "Lyon"}, wantAnswer: "Lyon", wantAgreement: 0.5},
+		{name: "exact", answers: []string{"Paris.", "paris", "Lyon"}, wantAnswer: "Paris.", wantAgreement: 1.0 / 3},
+		{name: "normalized", normalize: llm.NormalizeAnswer, answers: []string{"Lyon", "Paris.", " paris "}, wantAnswer: "Paris.", wantAgreement: 2.0 / 3},
+		{name: "single", answers: []string{"Paris"}, wantAnswer: "Paris", wantAgreement: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := llm.MajorityVote(tt.normalize)(context.Background(), nil, tt.answers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Answer != tt.wantAnswer || got.Agreement != tt.wantAgreement {
+				t.Errorf("got %q with agreement %.2f, want %q with %.2f", got.Answer, got.Agreement, tt.wantAnswer, tt.wantAgreement)
+			}
+		})
+	}
+
+	if _, err := llm.MajorityVote(nil)(context.Background(), nil, nil); err == nil {
+		t.Error("got no error for no answers")
+	}
+}
+
+func TestSelfConsistency(t *testing.T) {
+	// The first two samples fail and are left out of the vote.
+	var calls atomic.Int32
+	fake := &llmtest.Client{
+		Answer: func(ctx context.Context, messages []client.ChatInputMessage) (string, error) {
+			switch calls.Add(1) {
+			case 1, 2:
+				return "", errors.New("service unavailable")
+			case 3:
+				return "Lyon", nil
+			default:
+				return "Paris.", nil
+			}
+		},
+	}
+
+	sc := llm.SelfConsistency{Client: fake, Samples: 5, Parallel: 1, Aggregate: llm.MajorityVote(llm.NormalizeAnswer)}
+	input := client.ChatInput{
+		Model:    client.Models.Hermes2ProLlama38B,
+		Messages: []client.ChatInputMessage{{Role: client.Roles.User, Content: "What is the capital of France?"}},
+	}
+	got, err := sc.Chat(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Answer != "Paris." || got.Agreement != 2.0/3 || got.Failed != 2 || len(got.Answers) != 3 {
+		t.Errorf("got %+v, want Paris. agreed by 2 of 3 answers with 2 failed", got)
+	}
+
+	// Only when every sample fails is there an error.
+	sc.Client = &llmtest.Client{Err: errors.New("service unavailable")}
+	if _, err := sc.Chat(context.Background(), input); err == nil {
+		t.Error("got no error when every sample failed")
+	}
+}
+
+func TestJudgeVote(t *testing.T) {
+	fake := &llmtest.Client{Answers: []string{`{"answer": 2, "agreeing": [2, 3, 7]}`}}
+	spec := llm.DefaultRegistry[llm.DefaultModel]
+	messages := []client.ChatInputMessage{{Role: client.Roles.User, Content: "Where is the Eiffel Tower?"}}
+	answers := []string{"In Lyon.", "In Paris.", "It is in Paris, France."}
+
+	got, err := llm.JudgeVote(fake, spec, prompt.Default())(context.Background(), messages, answers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Numbers of answers that do not exist are ignored.
+	if got.Answer != "In Paris." || got.Agreement != 2.0/3 {
+		t.Errorf("got %q with agreement %.2f, want In Paris. with 0.67", got.Answer, got.Agreement)
+	}
+
+	calls := fake.CallsOf(llm.OpChat)
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls to the judge, want 1", len(calls))
+	}
+	content := calls[0].Messages[len(calls[0].Messages)-1].Content
+	for _, want := range []string{"Where is the Eiffel Tower?", "Answer 3:\n<answer>\nIt is in Paris, France.\n</answer>"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("judge prompt does not contain %q:\n%s", want, content)
+		}
+	}
+}
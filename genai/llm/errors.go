@@ -0,0 +1,172 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/predictionguard/go-client"
+)
+
+// Kind classifies the errors of calls to the API by how to react to them.
+type Kind int
+
+// Set of error kinds.
+const (
+	KindOther Kind = iota
+	KindAuth
+	KindRateLimit
+	KindTimeout
+	KindServer
+)
+
+// String implements the fmt.Stringer interface.
+func (k Kind) String() string {
+	switch k {
+	case KindAuth:
+		return "auth"
+	case KindRateLimit:
+		return "rate-limit"
+	case KindTimeout:
+		return "timeout"
+	case KindServer:
+		return "server"
+	}
+	return "other"
+}
+
+// Retryable reports if a call that failed with this kind of error can
+// succeed when it is made again.
+func (k Kind) Retryable() bool {
+	return k == KindRateLimit || k == KindTimeout || k == KindServer
+}
+
+// StatusError is returned for a response with an unsuccessful status.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Body       string
+}
+
+// Error implements the error interface.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+// Error is returned when a call fails for good, after any retries.
+type Error struct {
+	Kind     Kind
+	Attempts int
+	Err      error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s error after %d attempt(s): %s", e.Kind, e.Attempts, e.Err)
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns the kind of an error returned by a call to the API.
+func Classify(err error) Kind {
+	var llmErr *Error
+	if errors.As(err, &llmErr) {
+		return llmErr.Kind
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch code := statusErr.StatusCode; {
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+			return KindAuth
+		case code == http.StatusTooManyRequests:
+			return KindRateLimit
+		case code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout:
+			return KindTimeout
+		case code >= 500:
+			return KindServer
+		}
+		return KindOther
+	}
+
+	if errors.Is(err, client.ErrUnauthorized) {
+		return KindAuth
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return KindTimeout
+	}
+
+	return KindOther
+}
+
+// retryAfter returns how long the API asked to wait before the next call,
+// if it did.
+func retryAfter(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter parses a Retry-After header, given in seconds or as a
+// date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// =============================================================================
+
+// Transport is an http.RoundTripper that turns responses with an error
+// status, 4xx or 5xx, into a *StatusError, and leaves redirects for the
+// http.Client to follow. The Prediction Guard client only keeps the
+// message of a failed call, this way the status code and Retry-After
+// header survive to classify the error.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	return nil, &StatusError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		Body:       string(body),
+	}
+}
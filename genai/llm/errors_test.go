@@ -0,0 +1,70 @@
+package llm_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+)
+
+func TestTransport(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/cached", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})
+	mux.HandleFunc("/busy", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "2")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cln := &http.Client{Transport: llm.Transport{}}
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantErr    *llm.StatusError
+	}{
+		{path: "/ok", wantStatus: http.StatusOK},
+		{path: "/moved", wantStatus: http.StatusOK},
+		{path: "/cached", wantStatus: http.StatusNotModified},
+		{path: "/missing", wantErr: &llm.StatusError{StatusCode: http.StatusNotFound}},
+		{path: "/busy", wantErr: &llm.StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 2 * time.Second}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := cln.Get(srv.URL + tt.path)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				defer resp.Body.Close()
+				if resp.StatusCode != tt.wantStatus {
+					t.Errorf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+				}
+				return
+			}
+
+			var statusErr *llm.StatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("got error %v, want a *llm.StatusError", err)
+			}
+			if statusErr.StatusCode != tt.wantErr.StatusCode || statusErr.RetryAfter != tt.wantErr.RetryAfter {
+				t.Errorf("got status %d after %s, want %d after %s",
+					statusErr.StatusCode, statusErr.RetryAfter, tt.wantErr.StatusCode, tt.wantErr.RetryAfter)
+			}
+		})
+	}
+}
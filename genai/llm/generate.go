@@ -0,0 +1,191 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/predictionguard/go-client"
+)
+
+// SchemaError is returned by Generate when the model still does not
+// answer with valid JSON after the repairs.
+type SchemaError struct {
+	Errors   []string
+	Response string
+	Attempts int
+}
+
+// Error implements the error interface.
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("invalid JSON after %d attempt(s): %s", e.Attempts, strings.Join(e.Errors, "; "))
+}
+
+// Generate asks a chat model for a JSON value of type T, described to the
+// model by the JSON Schema of T. An answer that is not valid is sent back
+// with what is wrong with it, up to repairs times, before a *SchemaError
+// is returned.
+func Generate[T any](ctx context.Context, cln Client, spec ModelSpec, messages []client.ChatInputMessage, repairs int) (T, error) {
+	var zero T
+
+	if err := spec.Supports(OpChat); err != nil {
+		return zero, err
+	}
+
+	schema := SchemaOf[T]()
+	schemaJSON, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return zero, fmt.Errorf("schema: %w", err)
+	}
+
+	instruction := "Respond only with a JSON value that conforms to this JSON Schema, without any other text:\n\n" + string(schemaJSON)
+	messages = withInstruction(messages, instruction)
+
+	for attempt := 1; ; attempt++ {
+		input := client.ChatInput{
+			Model:       spec.Model,
+			Messages:    messages,
+			MaxTokens:   spec.Defaults.MaxTokens,
+			Temperature: spec.Defaults.Temperature,
+		}
+
+		resp, err := cln.Chat(ctx, input)
+		if err != nil {
+			return zero, fmt.Errorf("chat: %w", err)
+		}
+		if len(resp.Choices) == 0 {
+			return zero, errors.New("chat: no choices in response")
+		}
+		answer := resp.Choices[0].Message.Content
+
+		v, errs := decode[T](schema, answer)
+		if len(errs) == 0 {
+			return v, nil
+		}
+		if attempt > repairs {
+			return zero, &SchemaError{Errors: errs, Response: answer, Attempts: attempt}
+		}
+
+		// Show the model its answer and what is wrong with it.
+		messages = append(messages,
+			client.ChatInputMessage{Role: client.Roles.Assistant, Content: answer},
+			client.ChatInputMessage{Role: client.Roles.User, Content: "That JSON is not valid:\n\n- " + strings.Join(errs, "\n- ") + "\n\nRespond again with only the corrected JSON."},
+		)
+	}
+}
+
+// decode extracts the JSON value of an answer, validates it against the
+// schema and decodes it into a T.
+func decode[T any](schema *Schema, answer string) (T, []string) {
+	var v T
+
+	// Look for the kind of value the schema asks for, so a reference
+	// like [1] before an object is not taken for the value.
+	opening := "{["
+	switch schema.Type {
+	case "object":
+		opening = "{"
+	case "array":
+		opening = "["
+	}
+	data := extractJSON(answer, opening)
+
+	var raw any
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return v, []string{"not JSON: " + err.Error()}
+	}
+	if errs := schema.Validate(raw); len(errs) > 0 {
+		return v, errs
+	}
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		return v, []string{err.Error()}
+	}
+
+	return v, nil
+}
+
+// extractJSON returns the first complete JSON value of an answer that
+// starts with one of the opening brackets, without the markdown code
+// fence or the text models tend to put around it. Brackets are matched
+// outside of JSON strings only, so brackets in the text around the value,
+// like a reference to [1], or in its strings do not cut it short. An
+// answer without a complete value is returned from its first opening
+// bracket, for the decoder to report what is wrong with it.
+func extractJSON(answer string, opening string) string {
+	answer = strings.TrimSpace(answer)
+
+	first := -1
+	for start := 0; start < len(answer); start++ {
+		if !strings.ContainsRune(opening, rune(answer[start])) {
+			continue
+		}
+		if first < 0 {
+			first = start
+		}
+		if end := matchBracket(answer, start); end > 0 && json.Valid([]byte(answer[start:end])) {
+			return answer[start:end]
+		}
+	}
+
+	if first < 0 {
+		return answer
+	}
+	return answer[first:]
+}
+
+// matchBracket returns the end of the value that starts with the bracket
+// at start, just after its matching bracket, or -1 if it is not closed.
+func matchBracket(text string, start int) int {
+	var stack []byte
+	inString, escaped := false, false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				return -1
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// withInstruction returns the messages with an instruction added to the
+// system message, or in a new system message if there is none.
+func withInstruction(messages []client.ChatInputMessage, instruction string) []client.ChatInputMessage {
+	msgs := make([]client.ChatInputMessage, 0, len(messages)+1)
+	if len(messages) == 0 || messages[0].Role != client.Roles.System {
+		msgs = append(msgs, client.ChatInputMessage{Role: client.Roles.System, Content: instruction})
+		return append(msgs, messages...)
+	}
+
+	msgs = append(msgs, client.ChatInputMessage{
+		Role:    client.Roles.System,
+		Content: messages[0].Content + "\n\n" + instruction,
+	})
+	return append(msgs, messages[1:]...)
+}
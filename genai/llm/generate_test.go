@@ -0,0 +1,49 @@
+package llm
+
+import "testing"
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  string
+		opening string
+		want    string
+	}{
+		{name: "bare", answer: `{"a": 1}`, opening: "{", want: `{"a": 1}`},
+		{name: "fenced", answer: "```json\n{\"a\": 1}\n```", opening: "{", want: `{"a": 1}`},
+		{name: "prefixed", answer: `Here is the JSON: {"a": 1}`, opening: "{", want: `{"a": 1}`},
+		{name: "trailing text", answer: `{"a": 1} I hope this helps {you}.`, opening: "{", want: `{"a": 1}`},
+		{name: "references", answer: `See [1]: {"a": [1, 2]} (per [2])`, opening: "{", want: `{"a": [1, 2]}`},
+		{name: "brackets in strings", answer: `{"a": "} and ]", "b": "\"{"}`, opening: "{", want: `{"a": "} and ]", "b": "\"{"}`},
+		{name: "array", answer: `The list: ["a", "b"]. Done [sic]`, opening: "[", want: `["a", "b"]`},
+		{name: "any value", answer: `Answer: [1, {"a": 2}] {"b": 3}`, opening: "{[", want: `[1, {"a": 2}]`},
+		{name: "not JSON in braces", answer: `Use {name} here: {"a": 1}`, opening: "{", want: `{"a": 1}`},
+		{name: "unclosed", answer: `Sure: {"a": [1, 2}`, opening: "{", want: `{"a": [1, 2}`},
+		{name: "no value", answer: " no JSON here ", opening: "{", want: "no JSON here"},
+	}
+	for _, tt := range tests {
+		if got := extractJSON(tt.answer, tt.opening); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	type answer struct {
+		Answer  string   `json:"answer"`
+		Sources []string `json:"sources"`
+	}
+	schema := SchemaOf[answer]()
+
+	got, errs := decode[answer](schema, "According to [1], the answer is:\n```json\n{\"answer\": \"42\", \"sources\": [\"[1]\"]}\n```")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got.Answer != "42" || len(got.Sources) != 1 || got.Sources[0] != "[1]" {
+		t.Errorf("got %+v", got)
+	}
+
+	if _, errs := decode[answer](schema, `{"answer": 42}`); len(errs) != 2 {
+		t.Errorf("got errors %q, want a mistyped and a missing property", errs)
+	}
+}
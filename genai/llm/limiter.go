@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket rate limiter per model. It is shared by every
+// goroutine that calls the API, so together they stay under the limit.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket holds the tokens left for one model.
+type bucket struct {
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+// NewLimiter constructs a Limiter that allows perSecond calls per second to
+// each model, with bursts of up to burst calls. A perSecond of zero means
+// no limit.
+func NewLimiter(perSecond float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    perSecond,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// bucket returns the bucket of a model, refilled up to now. It must be
+// called with the mutex held.
+func (l *Limiter) bucket(model string, now time.Time) *bucket {
+	b, exists := l.buckets[model]
+	if !exists {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[model] = b
+	}
+
+	if l.rate > 0 {
+		b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	} else {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	return b
+}
+
+// Wait blocks until a call to the model is allowed or the context is done.
+// A nil Limiter never blocks, and one without a rate only blocks while the
+// model is paused.
+func (l *Limiter) Wait(ctx context.Context, model string) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		b := l.bucket(model, now)
+
+		var wait time.Duration
+		switch {
+		case now.Before(b.pausedUntil):
+			wait = b.pausedUntil.Sub(now)
+		case b.tokens >= 1:
+			b.tokens--
+			l.mu.Unlock()
+			return nil
+		default:
+			wait = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		}
+		l.mu.Unlock()
+
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Pause holds back every call to the model for a while, like when the API
+// says it is rate limited.
+func (l *Limiter) Pause(model string, d time.Duration) {
+	if l == nil || d <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b := l.bucket(model, now)
+	if until := now.Add(d); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// sleep waits for a duration or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
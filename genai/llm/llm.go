@@ -0,0 +1,40 @@
+// Package llm provides a resilient layer over the Prediction Guard client.
+// Failed calls are classified, retried with jittered exponential backoff
+// that honours Retry-After, and rate limited per model by a token bucket
+// shared between goroutines. Streams are consumed as iterators, and the
+// token usage and cost of the calls are metered.
+package llm
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/predictionguard/go-client"
+)
+
+// Client is the set of Prediction Guard API calls made by the pipeline. A
+// *client.Client implements it, and so do the wrappers in this package.
+type Client interface {
+	Completions(ctx context.Context, input client.CompletionInput) (client.Completion, error)
+	Chat(ctx context.Context, input client.ChatInput) (client.Chat, error)
+	ChatSSE(ctx context.Context, input client.ChatSSEInput, ch chan client.ChatSSE) error
+	Embedding(ctx context.Context, input []client.EmbeddingInput) (client.Embedding, error)
+	Factuality(ctx context.Context, reference string, text string) (client.Factuality, error)
+}
+
+// Names the limiter uses for calls that do not take a model.
+var (
+	EmbeddingModel  = client.Models.BridgetowerLargeItmMlmItc.String()
+	FactualityModel = "factuality"
+)
+
+// NewClient constructs a Prediction Guard client that uses the Transport,
+// so its errors carry the status code and Retry-After header of a failed
+// call and can be classified.
+func NewClient(log client.Logger, host string, apiKey string) *client.Client {
+	http := http.Client{
+		Transport: Transport{},
+	}
+
+	return client.New(log, host, apiKey, client.WithClient(&http))
+}
@@ -0,0 +1,196 @@
+// Package llmtest provides a fake of the Prediction Guard API that answers
+// from canned responses, so the pipelines built on an llm.Client can be
+// tested without calling the API.
+package llmtest
+
+import (
+	"cmp"
+	"context"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/predictionguard/go-client"
+)
+
+// OpFactuality is the operation of the calls that check factuality, which
+// are not made to a model.
+const OpFactuality llm.Operation = "factuality"
+
+// Call is a call made to the fake: the operation, the model and the
+// messages or text it was called with.
+type Call struct {
+	Op       llm.Operation
+	Model    string
+	Messages []client.ChatInputMessage
+	Text     string
+}
+
+// Client is a fake llm.Client. Chat and completion calls are answered by
+// Answer, if set, and otherwise with the Answers in turn, the last one
+// repeated once they run out. Texts are embedded into vectors of
+// Dimensions counts of their words, so texts that share words are
+// similar; the dimensions default to 512. Every factuality check scores
+// Score. An Err fails every call. It is safe for concurrent use.
+type Client struct {
+	Answers    []string
+	Answer     func(ctx context.Context, messages []client.ChatInputMessage) (string, error)
+	Dimensions int
+	Score      float64
+	Err        error
+
+	mu    sync.Mutex
+	calls []Call
+	next  int
+}
+
+// Calls returns the calls made to the fake, in order.
+func (c *Client) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Call(nil), c.calls...)
+}
+
+// CallsOf returns the calls of an operation made to the fake, in order.
+func (c *Client) CallsOf(op llm.Operation) []Call {
+	var calls []Call
+	for _, call := range c.Calls() {
+		if call.Op == op {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// record records a call and returns the canned answer that is next.
+func (c *Client) record(call Call) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, call)
+	if call.Op != llm.OpChat && call.Op != llm.OpCompletion {
+		return ""
+	}
+	if len(c.Answers) == 0 {
+		return ""
+	}
+	answer := c.Answers[min(c.next, len(c.Answers)-1)]
+	c.next++
+	return answer
+}
+
+// answer returns the answer to the messages of a call.
+func (c *Client) answer(ctx context.Context, call Call) (string, error) {
+	canned := c.record(call)
+	if c.Err != nil {
+		return "", c.Err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if c.Answer != nil {
+		return c.Answer(ctx, call.Messages)
+	}
+	return canned, nil
+}
+
+// Completions implements the llm.Client interface.
+func (c *Client) Completions(ctx context.Context, input client.CompletionInput) (client.Completion, error) {
+	messages := []client.ChatInputMessage{{Role: client.Roles.User, Content: input.Prompt}}
+	answer, err := c.answer(ctx, Call{Op: llm.OpCompletion, Model: input.Model.String(), Messages: messages, Text: input.Prompt})
+	if err != nil {
+		return client.Completion{}, err
+	}
+
+	return client.Completion{
+		Choices: []client.CompletionChoice{{Text: answer}},
+	}, nil
+}
+
+// Chat implements the llm.Client interface.
+func (c *Client) Chat(ctx context.Context, input client.ChatInput) (client.Chat, error) {
+	answer, err := c.answer(ctx, Call{Op: llm.OpChat, Model: input.Model.String(), Messages: input.Messages})
+	if err != nil {
+		return client.Chat{}, err
+	}
+
+	return client.Chat{
+		Model: input.Model,
+		Choices: []client.ChatChoice{
+			{Message: client.ChatMessage{Role: client.Roles.Assistant, Content: answer}},
+		},
+	}, nil
+}
+
+// ChatSSE implements the llm.Client interface. The answer is streamed a
+// word at a time, and the last message carries the finish reason "stop".
+func (c *Client) ChatSSE(ctx context.Context, input client.ChatSSEInput, ch chan client.ChatSSE) error {
+	answer, err := c.answer(ctx, Call{Op: llm.OpChat, Model: input.Model.String(), Messages: input.Messages})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer close(ch)
+
+		for _, word := range strings.SplitAfter(answer, " ") {
+			select {
+			case ch <- client.ChatSSE{Model: input.Model, Choices: []client.ChatSSEChoice{{Delta: client.ChatSSEDelta{Content: word}}}}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case ch <- client.ChatSSE{Model: input.Model, Choices: []client.ChatSSEChoice{{FinishReason: "stop"}}}:
+		case <-ctx.Done():
+		}
+	}()
+
+	return nil
+}
+
+// Embedding implements the llm.Client interface.
+func (c *Client) Embedding(ctx context.Context, input []client.EmbeddingInput) (client.Embedding, error) {
+	var resp client.Embedding
+	for i, in := range input {
+		if _, err := c.answer(ctx, Call{Op: llm.OpEmbedding, Model: llm.EmbeddingModel, Text: in.Text}); err != nil {
+			return client.Embedding{}, err
+		}
+		resp.Data = append(resp.Data, client.EmbeddingData{Index: i, Embedding: c.Vector(in.Text)})
+	}
+	return resp, nil
+}
+
+// Factuality implements the llm.Client interface.
+func (c *Client) Factuality(ctx context.Context, reference string, text string) (client.Factuality, error) {
+	if _, err := c.answer(ctx, Call{Op: OpFactuality, Model: llm.FactualityModel, Text: text}); err != nil {
+		return client.Factuality{}, err
+	}
+
+	return client.Factuality{
+		Checks: []client.FactualityCheck{{Score: c.Score}},
+	}, nil
+}
+
+// Vector returns the vector the fake embeds a text into: the count of each
+// of its words, lowercased, in a dimension picked by its hash. A text
+// without words points in the first dimension, so no vector is all zeros.
+func (c *Client) Vector(text string) []float64 {
+	dimensions := cmp.Or(c.Dimensions, 512)
+
+	vector := make([]float64, dimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	})
+	for _, word := range words {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		vector[h.Sum32()%uint32(dimensions)]++
+	}
+	if len(words) == 0 {
+		vector[0] = 1
+	}
+	return vector
+}
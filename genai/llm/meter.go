@@ -0,0 +1,198 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/predictionguard/go-client"
+)
+
+// Meter is a Client that records the usage of the calls that succeed, per
+// model. The API does not report usage, so it is estimated from the text
+// sent and received. One Meter is used for a session or an ingestion job.
+type Meter struct {
+	Client Client
+	Prices Prices
+
+	mu     sync.Mutex
+	models map[string]*ModelUsage
+}
+
+// ModelUsage is the usage of a model over a number of calls.
+type ModelUsage struct {
+	Model string
+	Calls int
+	Usage Usage
+}
+
+// Completions implements the Client interface.
+func (m *Meter) Completions(ctx context.Context, input client.CompletionInput) (client.Completion, error) {
+	resp, err := m.Client.Completions(ctx, input)
+	if err != nil {
+		return resp, err
+	}
+
+	var completion int
+	for _, choice := range resp.Choices {
+		completion += EstimateTokens(choice.Text)
+	}
+	m.Record(input.Model.String(), Usage{
+		PromptTokens:     EstimateTokens(input.Prompt),
+		CompletionTokens: completion,
+		Estimated:        true,
+	})
+
+	return resp, nil
+}
+
+// Chat implements the Client interface.
+func (m *Meter) Chat(ctx context.Context, input client.ChatInput) (client.Chat, error) {
+	resp, err := m.Client.Chat(ctx, input)
+	if err != nil {
+		return resp, err
+	}
+
+	var completion int
+	for _, choice := range resp.Choices {
+		completion += EstimateTokens(choice.Message.Content)
+	}
+	m.Record(input.Model.String(), Usage{
+		PromptTokens:     EstimateMessagesTokens(input.Messages),
+		CompletionTokens: completion,
+		Estimated:        true,
+	})
+
+	return resp, nil
+}
+
+// ChatSSE implements the Client interface. The messages are passed on to
+// ch as they arrive and the usage is recorded once the stream ends.
+func (m *Meter) ChatSSE(ctx context.Context, input client.ChatSSEInput, ch chan client.ChatSSE) error {
+	inner := make(chan client.ChatSSE, cap(ch))
+	if err := m.Client.ChatSSE(ctx, input, inner); err != nil {
+		return err
+	}
+
+	go func() {
+		defer close(ch)
+
+		var completion strings.Builder
+		for resp := range inner {
+			for _, choice := range resp.Choices {
+				completion.WriteString(choice.Delta.Content)
+			}
+
+			// Keep draining the stream when nobody is reading anymore.
+			select {
+			case ch <- resp:
+			case <-ctx.Done():
+			}
+		}
+
+		m.Record(input.Model.String(), Usage{
+			PromptTokens:     EstimateMessagesTokens(input.Messages),
+			CompletionTokens: EstimateTokens(completion.String()),
+			Estimated:        true,
+		})
+	}()
+
+	return nil
+}
+
+// Embedding implements the Client interface. Only the text of the inputs
+// is counted.
+func (m *Meter) Embedding(ctx context.Context, input []client.EmbeddingInput) (client.Embedding, error) {
+	resp, err := m.Client.Embedding(ctx, input)
+	if err != nil {
+		return resp, err
+	}
+
+	var prompt int
+	for _, in := range input {
+		prompt += EstimateTokens(in.Text)
+	}
+	m.Record(EmbeddingModel, Usage{PromptTokens: prompt, Estimated: true})
+
+	return resp, nil
+}
+
+// Factuality implements the Client interface.
+func (m *Meter) Factuality(ctx context.Context, reference string, text string) (client.Factuality, error) {
+	resp, err := m.Client.Factuality(ctx, reference, text)
+	if err != nil {
+		return resp, err
+	}
+
+	m.Record(FactualityModel, Usage{
+		PromptTokens: EstimateTokens(reference) + EstimateTokens(text),
+		Estimated:    true,
+	})
+
+	return resp, nil
+}
+
+// Record adds the usage of a call to a model.
+func (m *Meter) Record(model string, usage Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.models == nil {
+		m.models = make(map[string]*ModelUsage)
+	}
+	mu, exists := m.models[model]
+	if !exists {
+		mu = &ModelUsage{Model: model}
+		m.models[model] = mu
+	}
+	mu.Calls++
+	mu.Usage = mu.Usage.Add(usage)
+}
+
+// Report returns the usage recorded so far and its cost.
+func (m *Meter) Report() Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var r Report
+	for _, mu := range m.models {
+		r.Models = append(r.Models, *mu)
+		r.Total = r.Total.Add(mu.Usage)
+	}
+	sort.Slice(r.Models, func(i, j int) bool {
+		return r.Models[i].Model < r.Models[j].Model
+	})
+
+	r.Cost, r.Priced = m.Prices.Cost(r.Models)
+
+	return r
+}
+
+// Report is the usage of a session or an ingestion job per model. Cost is
+// in dollars and only Priced when every model is in the price table.
+type Report struct {
+	Models []ModelUsage
+	Total  Usage
+	Cost   float64
+	Priced bool
+}
+
+// String implements the fmt.Stringer interface.
+func (r Report) String() string {
+	if len(r.Models) == 0 {
+		return "No calls to the API"
+	}
+
+	var b strings.Builder
+	for _, mu := range r.Models {
+		fmt.Fprintf(&b, "%s: %d calls, %s\n", mu.Model, mu.Calls, mu.Usage)
+	}
+	fmt.Fprintf(&b, "Total: %s", r.Total)
+	if r.Priced {
+		fmt.Fprintf(&b, ", $%.4f", r.Cost)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,191 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/predictionguard/go-client"
+)
+
+// Operation is something a model is used for.
+type Operation string
+
+// Set of operations.
+const (
+	OpChat       Operation = "chat"
+	OpCompletion Operation = "completion"
+	OpEmbedding  Operation = "embedding"
+	OpVision     Operation = "vision"
+)
+
+// ModelEnv is the environment variable that selects the chat model.
+const ModelEnv = "GENAI_MODEL"
+
+// DefaultModel is the chat model used when none is selected.
+const DefaultModel = "Hermes-2-Pro-Llama-3-8B"
+
+// Params are the default parameters of calls to a model.
+type Params struct {
+	MaxTokens   int     `json:"max_tokens"`
+	Temperature float32 `json:"temperature"`
+}
+
+// ModelSpec describes a model: what it can be used for, how long its
+// context is, the format of its raw prompts (one of the Templates), the
+// dimension of its embeddings and the parameters to call it with by
+// default.
+type ModelSpec struct {
+	Model              client.Model `json:"-"`
+	Operations         []Operation  `json:"operations"`
+	ContextLength      int          `json:"context_length"`
+	PromptFormat       string       `json:"prompt_format,omitempty"`
+	EmbeddingDimension int          `json:"embedding_dimension,omitempty"`
+	Vision             bool         `json:"vision,omitempty"`
+	Defaults           Params       `json:"defaults"`
+}
+
+// Name returns the name of the model.
+func (s ModelSpec) Name() string {
+	return s.Model.String()
+}
+
+// Supports returns an error if the model cannot be used for an operation.
+func (s ModelSpec) Supports(op Operation) error {
+	if op == OpVision && s.Vision {
+		return nil
+	}
+	if slices.Contains(s.Operations, op) {
+		return nil
+	}
+	return fmt.Errorf("model %s does not support %s", s.Name(), op)
+}
+
+// Registry is a set of model specs by name.
+type Registry map[string]ModelSpec
+
+// DefaultRegistry describes the models of the API.
+var DefaultRegistry = Registry{
+	"Hermes-2-Pro-Llama-3-8B": {
+		Model:         client.Models.Hermes2ProLlama38B,
+		Operations:    []Operation{OpChat, OpCompletion},
+		ContextLength: 8192,
+		PromptFormat:  "chatml",
+		Defaults:      Params{MaxTokens: 1000, Temperature: 0.3},
+	},
+	"Hermes-2-Pro-Mistral-7B": {
+		Model:         client.Models.Hermes2ProMistral7B,
+		Operations:    []Operation{OpChat, OpCompletion},
+		ContextLength: 8192,
+		PromptFormat:  "chatml",
+		Defaults:      Params{MaxTokens: 1000, Temperature: 0.3},
+	},
+	"Neural-Chat-7B": {
+		Model:         client.Models.NeuralChat7B,
+		Operations:    []Operation{OpChat, OpCompletion},
+		ContextLength: 4096,
+		PromptFormat:  "neural-chat",
+		Defaults:      Params{MaxTokens: 1000, Temperature: 0.3},
+	},
+	"Nous-Hermes-Llama-213B": {
+		Model:         client.Models.NousHermesLlama213B,
+		Operations:    []Operation{OpChat, OpCompletion},
+		ContextLength: 4096,
+		PromptFormat:  "alpaca",
+		Defaults:      Params{MaxTokens: 1000, Temperature: 0.3},
+	},
+	"deepseek-coder-6.7b-instruct": {
+		Model:         client.Models.DeepseekCoder67BInstruct,
+		Operations:    []Operation{OpChat, OpCompletion},
+		ContextLength: 16384,
+		PromptFormat:  "alpaca",
+		Defaults:      Params{MaxTokens: 1000, Temperature: 0.1},
+	},
+	"llama-3-sqlcoder-8b": {
+		Model:         client.Models.LLama3SqlCoder8b,
+		Operations:    []Operation{OpCompletion},
+		ContextLength: 8192,
+		PromptFormat:  "llama3",
+		Defaults:      Params{MaxTokens: 500, Temperature: 0.1},
+	},
+	"llava-1.5-7b-hf": {
+		Model:         client.Models.Llava157BHF,
+		Operations:    []Operation{OpChat},
+		ContextLength: 4096,
+		Vision:        true,
+		Defaults:      Params{MaxTokens: 1000, Temperature: 0.3},
+	},
+	"bridgetower-large-itm-mlm-itc": {
+		Model:         client.Models.BridgetowerLargeItmMlmItc,
+		Operations:    []Operation{OpEmbedding},
+		ContextLength: 512,
+		Vision:        true,
+	},
+}
+
+// Lookup returns the spec of a model by name, checking that it can be
+// used for an operation.
+func (r Registry) Lookup(name string, op Operation) (ModelSpec, error) {
+	spec, exists := r[name]
+	if !exists {
+		return ModelSpec{}, fmt.Errorf("unknown model %q, known models are %v", name, r.Names())
+	}
+	if err := spec.Supports(op); err != nil {
+		return ModelSpec{}, err
+	}
+	return spec, nil
+}
+
+// FromEnv returns the spec of the model named by $GENAI_MODEL, or of the
+// DefaultModel if it is not set, checking that it can be used for an
+// operation.
+func (r Registry) FromEnv(op Operation) (ModelSpec, error) {
+	name := os.Getenv(ModelEnv)
+	if name == "" {
+		name = DefaultModel
+	}
+	return r.Lookup(name, op)
+}
+
+// Names returns the names of the models in the registry.
+func (r Registry) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadRegistry reads model specs from a JSON file, keyed by model name,
+// and returns the default registry with them added or replaced. The
+// models must be known to the client.
+func LoadRegistry(path string) (Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var specs map[string]ModelSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	registry := maps.Clone(DefaultRegistry)
+	for name, spec := range specs {
+		model, err := client.Models.Parse(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if _, exists := Templates[spec.PromptFormat]; spec.PromptFormat != "" && !exists {
+			return nil, fmt.Errorf("%s: model %s: unknown prompt format %q", path, name, spec.PromptFormat)
+		}
+		spec.Model = model
+		registry[name] = spec
+	}
+
+	return registry, nil
+}
@@ -0,0 +1,52 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Price is the price of a model in dollars per million tokens.
+type Price struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// Prices is a price table by model name. The API does not publish prices,
+// so the table is provided by the user, like:
+//
+//	{
+//	  "Hermes-2-Pro-Llama-3-8B": {"prompt": 0.2, "completion": 0.2},
+//	  "bridgetower-large-itm-mlm-itc": {"prompt": 0.02}
+//	}
+type Prices map[string]Price
+
+// LoadPrices reads a price table from a JSON file.
+func LoadPrices(path string) (Prices, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var prices Prices
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return prices, nil
+}
+
+// Cost returns the cost in dollars of the usage of a set of models, and
+// reports if every model has a price.
+func (p Prices) Cost(models []ModelUsage) (float64, bool) {
+	var cost float64
+	for _, mu := range models {
+		price, exists := p[mu.Model]
+		if !exists {
+			return 0, false
+		}
+		cost += float64(mu.Usage.PromptTokens)*price.Prompt/1e6 +
+			float64(mu.Usage.CompletionTokens)*price.Completion/1e6
+	}
+	return cost, len(models) > 0
+}
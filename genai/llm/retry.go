@@ -0,0 +1,134 @@
+package llm
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/predictionguard/go-client"
+)
+
+// Retry is a Client that waits for the rate limiter before every call and
+// retries the calls that fail with a retryable error, using jittered
+// exponential backoff or the wait the API asked for, whichever is longer.
+// A call that still fails returns an *Error.
+type Retry struct {
+	Client      Client
+	Limiter     *Limiter
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Timeout     time.Duration
+	Log         client.Logger
+}
+
+// Completions implements the Client interface.
+func (r *Retry) Completions(ctx context.Context, input client.CompletionInput) (client.Completion, error) {
+	return retry(ctx, r, input.Model.String(), true, func(ctx context.Context) (client.Completion, error) {
+		return r.Client.Completions(ctx, input)
+	})
+}
+
+// Chat implements the Client interface.
+func (r *Retry) Chat(ctx context.Context, input client.ChatInput) (client.Chat, error) {
+	return retry(ctx, r, input.Model.String(), true, func(ctx context.Context) (client.Chat, error) {
+		return r.Client.Chat(ctx, input)
+	})
+}
+
+// ChatSSE implements the Client interface. Only starting the stream is
+// retried, and the per attempt timeout only bounds how long the stream
+// takes to start, since the stream outlives the call.
+func (r *Retry) ChatSSE(ctx context.Context, input client.ChatSSEInput, ch chan client.ChatSSE) error {
+	_, err := retry(ctx, r, input.Model.String(), false, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, startStream(ctx, r.Timeout, ch, func(ctx context.Context, ch chan client.ChatSSE) error {
+			return r.Client.ChatSSE(ctx, input, ch)
+		})
+	})
+	return err
+}
+
+// Embedding implements the Client interface.
+func (r *Retry) Embedding(ctx context.Context, input []client.EmbeddingInput) (client.Embedding, error) {
+	return retry(ctx, r, EmbeddingModel, true, func(ctx context.Context) (client.Embedding, error) {
+		return r.Client.Embedding(ctx, input)
+	})
+}
+
+// Factuality implements the Client interface.
+func (r *Retry) Factuality(ctx context.Context, reference string, text string) (client.Factuality, error) {
+	return retry(ctx, r, FactualityModel, true, func(ctx context.Context) (client.Factuality, error) {
+		return r.Client.Factuality(ctx, reference, text)
+	})
+}
+
+// backoff returns the jittered delay before the next attempt: a random
+// duration between half and all of the exponential delay for the attempt.
+func (r *Retry) backoff(attempt int) time.Duration {
+	base := r.BaseDelay
+	if base <= 0 {
+		base = 500 * time.Millisecond
+	}
+	maxDelay := r.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+
+	delay := base << (attempt - 1)
+	if delay <= 0 || delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (r *Retry) log(ctx context.Context, msg string, v ...any) {
+	if r.Log != nil {
+		r.Log(ctx, msg, v...)
+	}
+}
+
+// retry makes a call to a model until it succeeds, fails with an error
+// that is not retryable, runs out of attempts or the context is done.
+func retry[T any](ctx context.Context, r *Retry, model string, timeout bool, call func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+
+	maxAttempts := max(r.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		if err := r.Limiter.Wait(ctx, model); err != nil {
+			return zero, err
+		}
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout && r.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, r.Timeout)
+		}
+		resp, err := call(attemptCtx)
+		cancel()
+
+		if err == nil {
+			return resp, nil
+		}
+
+		kind := Classify(err)
+		if ctx.Err() != nil || !kind.Retryable() || attempt == maxAttempts {
+			return zero, &Error{Kind: kind, Attempts: attempt, Err: err}
+		}
+
+		// Wait at least as long as the API asked for, and hold back the
+		// other goroutines calling the model when it is rate limited.
+		delay := r.backoff(attempt)
+		if after := retryAfter(err); after > delay {
+			delay = after
+		}
+		if kind == KindRateLimit {
+			r.Limiter.Pause(model, delay)
+		}
+
+		r.log(ctx, "retry: call failed", "model", model, "kind", kind, "attempt", attempt, "delay", delay, "error", err)
+
+		if err := sleep(ctx, delay); err != nil {
+			return zero, &Error{Kind: kind, Attempts: attempt, Err: err}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package llm_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/llm/llmtest"
+	"github.com/predictionguard/go-client"
+)
+
+func TestRetryStreamTimeout(t *testing.T) {
+	// The first stream never starts, the second one does.
+	var attempts atomic.Int32
+	fake := &llmtest.Client{
+		Answer: func(ctx context.Context, messages []client.ChatInputMessage) (string, error) {
+			if attempts.Add(1) == 1 {
+				return hang(ctx, messages)
+			}
+			return "A goroutine is a lightweight thread.", nil
+		},
+	}
+
+	r := &llm.Retry{
+		Client:      fake,
+		MaxAttempts: 2,
+		BaseDelay:   time.Millisecond,
+		Timeout:     50 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	input := client.ChatSSEInput{
+		Model:    client.Models.Hermes2ProLlama38B,
+		Messages: []client.ChatInputMessage{{Role: client.Roles.User, Content: "What is a goroutine?"}},
+	}
+	answer, err := llm.Collect(llm.Stream(ctx, r, input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("made %d attempts, want 2", attempts.Load())
+	}
+
+	// The timeout only bounds the start, not the stream that follows.
+	if answer.Content != "A goroutine is a lightweight thread." || answer.FinishReason != "stop" {
+		t.Errorf("got answer %q finished by %q, want the full answer", answer.Content, answer.FinishReason)
+	}
+}
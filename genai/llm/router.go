@@ -0,0 +1,288 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/predictionguard/go-client"
+)
+
+// ErrNoBackend is returned when no backend of a router can serve a call.
+var ErrNoBackend = errors.New("no backend can serve the request")
+
+// Backend is a model served by a client, like a model of an API or the
+// same model at another provider. Tier orders backends by cost, from 0
+// for the cheapest. A Timeout bounds how long a call to the backend, or
+// the start of a stream, can take before the next backend is tried, so a
+// backend that hangs leaves the others the rest of the deadline of the
+// call.
+type Backend struct {
+	Name    string
+	Client  Client
+	Model   ModelSpec
+	Tier    int
+	Timeout time.Duration
+}
+
+// Request holds the attributes of a call that backends are routed by.
+type Request struct {
+	Operation    Operation
+	Model        string
+	PromptTokens int
+	MaxTokens    int
+	Requirements
+}
+
+// Requirements are attributes of a call that its input does not tell,
+// set on its context with WithRequirements.
+type Requirements struct {
+	Vision  bool
+	MaxTier int
+}
+
+type requirementsKey struct{}
+
+// WithRequirements returns a context in which calls through a router are
+// only routed to the backends that meet the requirements. A MaxTier of
+// zero means any tier.
+func WithRequirements(ctx context.Context, req Requirements) context.Context {
+	return context.WithValue(ctx, requirementsKey{}, req)
+}
+
+// Policy narrows down and orders the backends that can serve a request.
+type Policy func(req Request, backends []Backend) []Backend
+
+// DefaultPolicies route a request to the backends that support its
+// operation, fit its prompt, have vision if needed and are within its
+// tier, preferring the model asked for.
+var DefaultPolicies = []Policy{SupportsOperation, FitsContext, HasVision, WithinTier, PreferRequested}
+
+// SupportsOperation keeps the backends whose model supports the operation.
+func SupportsOperation(req Request, backends []Backend) []Backend {
+	return filter(backends, func(b Backend) bool {
+		return b.Model.Supports(req.Operation) == nil
+	})
+}
+
+// FitsContext keeps the backends whose model has room for the prompt and
+// the answer.
+func FitsContext(req Request, backends []Backend) []Backend {
+	return filter(backends, func(b Backend) bool {
+		return b.Model.ContextLength == 0 || req.PromptTokens+req.MaxTokens <= b.Model.ContextLength
+	})
+}
+
+// HasVision keeps the backends with vision when the request needs it.
+func HasVision(req Request, backends []Backend) []Backend {
+	if !req.Vision {
+		return backends
+	}
+	return filter(backends, func(b Backend) bool {
+		return b.Model.Vision
+	})
+}
+
+// WithinTier keeps the backends within the cost tier of the request.
+func WithinTier(req Request, backends []Backend) []Backend {
+	if req.MaxTier == 0 {
+		return backends
+	}
+	return filter(backends, func(b Backend) bool {
+		return b.Tier <= req.MaxTier
+	})
+}
+
+// PreferRequested moves the backends of the model asked for to the front,
+// keeping the order of the rest as the fallback chain.
+func PreferRequested(req Request, backends []Backend) []Backend {
+	ordered := filter(backends, func(b Backend) bool { return b.Model.Name() == req.Model })
+	return append(ordered, filter(backends, func(b Backend) bool { return b.Model.Name() != req.Model })...)
+}
+
+func filter(backends []Backend, keep func(b Backend) bool) []Backend {
+	var kept []Backend
+	for _, b := range backends {
+		if keep(b) {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// Record is what a router did with a call: the backend that served it and
+// the ones that failed before it.
+type Record struct {
+	Operation Operation
+	Requested string
+	Backend   string
+	Failed    []string
+}
+
+// String implements the fmt.Stringer interface.
+func (r Record) String() string {
+	s := fmt.Sprintf("%s served by %s", r.Operation, r.Backend)
+	if len(r.Failed) > 0 {
+		s += " after " + strings.Join(r.Failed, ", ") + " failed"
+	}
+	return s
+}
+
+// Records collects the records of the calls made with a context.
+type Records struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+type recordsKey struct{}
+
+// WithRecords returns a context in which routers record which backend
+// served each call.
+func WithRecords(ctx context.Context) (context.Context, *Records) {
+	records := &Records{}
+	return context.WithValue(ctx, recordsKey{}, records), records
+}
+
+// List returns the records so far.
+func (r *Records) List() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record(nil), r.records...)
+}
+
+func (r *Records) add(record Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record)
+}
+
+// Router is a Client that routes the calls to models through the policies
+// to an ordered list of backends, and falls back to the next backend when
+// one fails. Calls that do not take a model go to the Client.
+type Router struct {
+	Client   Client
+	Backends []Backend
+	Policies []Policy
+	Log      client.Logger
+}
+
+// Completions implements the Client interface.
+func (r *Router) Completions(ctx context.Context, input client.CompletionInput) (client.Completion, error) {
+	req := Request{
+		Operation:    OpCompletion,
+		Model:        input.Model.String(),
+		PromptTokens: EstimateTokens(input.Prompt),
+		MaxTokens:    input.MaxTokens,
+	}
+
+	return route(ctx, r, req, true, func(ctx context.Context, b Backend) (client.Completion, error) {
+		input.Model = b.Model.Model
+		return b.Client.Completions(ctx, input)
+	})
+}
+
+// Chat implements the Client interface.
+func (r *Router) Chat(ctx context.Context, input client.ChatInput) (client.Chat, error) {
+	req := Request{
+		Operation:    OpChat,
+		Model:        input.Model.String(),
+		PromptTokens: EstimateMessagesTokens(input.Messages),
+		MaxTokens:    input.MaxTokens,
+	}
+
+	return route(ctx, r, req, true, func(ctx context.Context, b Backend) (client.Chat, error) {
+		input.Model = b.Model.Model
+		return b.Client.Chat(ctx, input)
+	})
+}
+
+// ChatSSE implements the Client interface. Only starting the stream falls
+// back to the next backend, and the Timeout of a backend only bounds how
+// long the stream takes to start.
+func (r *Router) ChatSSE(ctx context.Context, input client.ChatSSEInput, ch chan client.ChatSSE) error {
+	req := Request{
+		Operation:    OpChat,
+		Model:        input.Model.String(),
+		PromptTokens: EstimateMessagesTokens(input.Messages),
+		MaxTokens:    input.MaxTokens,
+	}
+
+	_, err := route(ctx, r, req, false, func(ctx context.Context, b Backend) (struct{}, error) {
+		input.Model = b.Model.Model
+		return struct{}{}, startStream(ctx, b.Timeout, ch, func(ctx context.Context, ch chan client.ChatSSE) error {
+			return b.Client.ChatSSE(ctx, input, ch)
+		})
+	})
+	return err
+}
+
+// Embedding implements the Client interface.
+func (r *Router) Embedding(ctx context.Context, input []client.EmbeddingInput) (client.Embedding, error) {
+	return r.Client.Embedding(ctx, input)
+}
+
+// Factuality implements the Client interface.
+func (r *Router) Factuality(ctx context.Context, reference string, text string) (client.Factuality, error) {
+	return r.Client.Factuality(ctx, reference, text)
+}
+
+// route calls the backends picked by the policies in order, until one
+// succeeds or the context is done. Unless timeout is false, each call has
+// the deadline of its backend, carved out of the one of the context.
+func route[T any](ctx context.Context, r *Router, req Request, timeout bool, call func(ctx context.Context, b Backend) (T, error)) (T, error) {
+	var zero T
+
+	if requirements, ok := ctx.Value(requirementsKey{}).(Requirements); ok {
+		req.Requirements = requirements
+	}
+
+	policies := r.Policies
+	if policies == nil {
+		policies = DefaultPolicies
+	}
+	backends := r.Backends
+	for _, policy := range policies {
+		backends = policy(req, backends)
+	}
+	if len(backends) == 0 {
+		return zero, fmt.Errorf("%s with %s: %w", req.Operation, req.Model, ErrNoBackend)
+	}
+
+	record := Record{Operation: req.Operation, Requested: req.Model}
+	var errs []error
+	for _, b := range backends {
+		callCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout && b.Timeout > 0 {
+			callCtx, cancel = context.WithTimeout(ctx, b.Timeout)
+		}
+		resp, err := call(callCtx, b)
+		cancel()
+
+		if err == nil {
+			record.Backend = b.Name
+			if records, ok := ctx.Value(recordsKey{}).(*Records); ok {
+				records.add(record)
+			}
+			if r.Log != nil && len(record.Failed) > 0 {
+				r.Log(ctx, "router: fell back", "record", record)
+			}
+			return resp, nil
+		}
+
+		// Only the context of the call running out ends the fallback, not
+		// the deadline of a backend.
+		if ctx.Err() != nil {
+			return zero, err
+		}
+		if r.Log != nil {
+			r.Log(ctx, "router: backend failed", "backend", b.Name, "error", err)
+		}
+		record.Failed = append(record.Failed, b.Name)
+		errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+	}
+
+	return zero, errors.Join(errs...)
+}
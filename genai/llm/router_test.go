@@ -0,0 +1,100 @@
+package llm_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/llm/llmtest"
+	"github.com/predictionguard/go-client"
+)
+
+// hang answers once the context of the call is done.
+func hang(ctx context.Context, messages []client.ChatInputMessage) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+// newRouter returns a router over a backend that hangs and one that
+// answers, with the first one given timeout.
+func newRouter(timeout time.Duration) (*llm.Router, *llmtest.Client) {
+	spec := llm.DefaultRegistry[llm.DefaultModel]
+	fallback := llm.DefaultRegistry["Hermes-2-Pro-Mistral-7B"]
+	answers := &llmtest.Client{Answers: []string{"A goroutine is a lightweight thread."}}
+
+	router := &llm.Router{
+		Backends: []llm.Backend{
+			{Name: "hanging", Client: &llmtest.Client{Answer: hang}, Model: spec, Timeout: timeout},
+			{Name: "fallback", Client: answers, Model: fallback},
+		},
+	}
+	return router, answers
+}
+
+func TestRouterBackendTimeout(t *testing.T) {
+	router, answers := newRouter(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx, records := llm.WithRecords(ctx)
+
+	input := client.ChatInput{
+		Model:    client.Models.Hermes2ProLlama38B,
+		Messages: []client.ChatInputMessage{{Role: client.Roles.User, Content: "What is a goroutine?"}},
+	}
+	resp, err := router.Chat(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Choices[0].Message.Content; got != "A goroutine is a lightweight thread." {
+		t.Errorf("got answer %q", got)
+	}
+	if calls := answers.CallsOf(llm.OpChat); len(calls) != 1 || calls[0].Model != "Hermes-2-Pro-Mistral-7B" {
+		t.Errorf("got calls %+v to the fallback, want one with its model", calls)
+	}
+
+	list := records.List()
+	if len(list) != 1 || list[0].Backend != "fallback" || len(list[0].Failed) != 1 || list[0].Failed[0] != "hanging" {
+		t.Errorf("got records %v, want the call served by the fallback after the hanging backend failed", list)
+	}
+}
+
+func TestRouterStreamTimeout(t *testing.T) {
+	router, _ := newRouter(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	input := client.ChatSSEInput{
+		Model:    client.Models.Hermes2ProLlama38B,
+		Messages: []client.ChatInputMessage{{Role: client.Roles.User, Content: "What is a goroutine?"}},
+	}
+	answer, err := llm.Collect(llm.Stream(ctx, router, input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Content != "A goroutine is a lightweight thread." || answer.FinishReason != "stop" {
+		t.Errorf("got answer %q finished by %q, want the full answer of the fallback", answer.Content, answer.FinishReason)
+	}
+}
+
+func TestRouterContextDone(t *testing.T) {
+	// Without a timeout of its own, the hanging backend takes the whole
+	// deadline of the call, and there is no time left to fall back.
+	router, answers := newRouter(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	input := client.ChatInput{
+		Model:    client.Models.Hermes2ProLlama38B,
+		Messages: []client.ChatInputMessage{{Role: client.Roles.User, Content: "What is a goroutine?"}},
+	}
+	if _, err := router.Chat(ctx, input); err == nil {
+		t.Fatal("got an answer, want the deadline of the call exceeded")
+	}
+	if calls := answers.CallsOf(llm.OpChat); len(calls) != 0 {
+		t.Errorf("got %d calls to the fallback after the deadline of the call, want 0", len(calls))
+	}
+}
@@ -0,0 +1,250 @@
+package llm
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of JSON Schema that describes Go types: objects,
+// arrays, strings, numbers, integers and booleans.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+// SchemaOf derives the schema of a Go type from its JSON encoding. Struct
+// fields are required unless they are pointers or tagged omitempty, and
+// are described by their description tag. A string field can be limited
+// to a set of values with an enum tag, like `enum:"low,medium,high"`.
+func SchemaOf[T any]() *Schema {
+	return schemaOf(reflect.TypeFor[T](), map[reflect.Type]bool{})
+}
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	rawMessageType    = reflect.TypeFor[json.RawMessage]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// schemaOf derives the schema of a type. A struct that contains itself is
+// only described once, as any object, where it recurs.
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	if t == rawMessageType {
+		return &Schema{}
+	}
+	if t.Implements(textMarshalerType) {
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), seen)
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return &Schema{Type: "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		s := &Schema{
+			Type:                 "object",
+			Properties:           map[string]*Schema{},
+			AdditionalProperties: false,
+		}
+		addFields(s, t, seen)
+		sort.Strings(s.Required)
+		return s
+	}
+
+	// Interfaces and anything else accept any value.
+	return &Schema{}
+}
+
+// addFields adds the fields of a struct to the schema of an object,
+// flattening embedded structs like encoding/json does.
+func addFields(s *Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(s, ft, seen)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := schemaOf(f.Type, seen)
+		fs.Description = f.Tag.Get("description")
+		if enum := f.Tag.Get("enum"); enum != "" {
+			fs.Enum = strings.Split(enum, ",")
+		}
+		s.Properties[name] = fs
+
+		optional := f.Type.Kind() == reflect.Pointer || slices.Contains(strings.Split(opts, ","), "omitempty")
+		if !optional {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// Validate checks a value decoded from JSON against the schema and
+// returns what is wrong with it, by JSON path. Null is only accepted for
+// the properties that are not required. A required slice or map is
+// rejected as null even though encoding/json would decode it as nil,
+// since the schema shown to the model asks for an array or an object.
+func (s *Schema) Validate(v any) []string {
+	var errs []string
+	s.validate("$", v, &errs)
+	return errs
+}
+
+func (s *Schema) validate(path string, v any, errs *[]string) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	switch s.Type {
+	case "":
+		return
+
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			fail("expected an object, got %s", jsonType(v))
+			return
+		}
+		for _, name := range s.Required {
+			if _, exists := obj[name]; !exists {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value := obj[name]
+			prop, exists := s.Properties[name]
+			switch {
+			case exists:
+				if value == nil && !slices.Contains(s.Required, name) {
+					continue
+				}
+				prop.validate(path+"."+name, value, errs)
+			case s.AdditionalProperties == false:
+				fail("unexpected property %q", name)
+			default:
+				if additional, ok := s.AdditionalProperties.(*Schema); ok {
+					additional.validate(path+"."+name, value, errs)
+				}
+			}
+		}
+
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			fail("expected an array, got %s", jsonType(v))
+			return
+		}
+		for i, item := range arr {
+			s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+		}
+
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("expected a string, got %s", jsonType(v))
+			return
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+			fail("%q is not one of %s", str, strings.Join(s.Enum, ", "))
+		}
+
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			fail("expected an integer, got %s", jsonType(v))
+		}
+
+	case "number":
+		if _, ok := v.(float64); !ok {
+			fail("expected a number, got %s", jsonType(v))
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("expected a boolean, got %s", jsonType(v))
+		}
+	}
+}
+
+// jsonType names the JSON type of a decoded value.
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "an integer"
+		}
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
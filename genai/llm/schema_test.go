@@ -0,0 +1,120 @@
+package llm
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type address struct {
+	City string `json:"city"`
+	Zip  string `json:"zip,omitempty"`
+}
+
+type person struct {
+	Name     string         `json:"name" description:"full name"`
+	Age      int            `json:"age"`
+	Email    *string        `json:"email"`
+	Tags     []string       `json:"tags"`
+	Home     address        `json:"home"`
+	Work     *address       `json:"work"`
+	Previous []address      `json:"previous,omitempty"`
+	Level    string         `json:"level" enum:"low,high"`
+	Extra    map[string]int `json:"extra,omitempty"`
+	Ignored  string         `json:"-"`
+	private  string
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := SchemaOf[person]()
+
+	want := []string{"age", "home", "level", "name", "tags"}
+	if !reflect.DeepEqual(s.Required, want) {
+		t.Errorf("got required %v, want %v", s.Required, want)
+	}
+
+	tests := []struct {
+		name string
+		got  *Schema
+		want string
+	}{
+		{name: "name", got: s.Properties["name"], want: `{"type":"string","description":"full name"}`},
+		{name: "pointer", got: s.Properties["email"], want: `{"type":"string"}`},
+		{name: "slice", got: s.Properties["tags"], want: `{"type":"array","items":{"type":"string"}}`},
+		{name: "nested", got: s.Properties["home"], want: `{"type":"object","properties":{"city":{"type":"string"},"zip":{"type":"string"}},"required":["city"],"additionalProperties":false}`},
+		{name: "pointer to struct", got: s.Properties["work"], want: `{"type":"object","properties":{"city":{"type":"string"},"zip":{"type":"string"}},"required":["city"],"additionalProperties":false}`},
+		{name: "slice of structs", got: s.Properties["previous"], want: `{"type":"array","items":{"type":"object","properties":{"city":{"type":"string"},"zip":{"type":"string"}},"required":["city"],"additionalProperties":false}}`},
+		{name: "enum", got: s.Properties["level"], want: `{"type":"string","enum":["low","high"]}`},
+		{name: "map", got: s.Properties["extra"], want: `{"type":"object","additionalProperties":{"type":"integer"}}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	for _, name := range []string{"Ignored", "private"} {
+		if _, ok := s.Properties[name]; ok {
+			t.Errorf("got property %q, want it left out", name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := SchemaOf[person]()
+
+	valid := `{"name": "Ann", "age": 30, "email": null, "tags": [], "home": {"city": "Oslo"}, "level": "low"}`
+
+	tests := []struct {
+		name string
+		json string
+		want []string
+	}{
+		{name: "valid", json: valid},
+		{
+			name: "missing",
+			json: `{"name": "Ann", "tags": [], "home": {}, "level": "low"}`,
+			want: []string{`$: missing required property "age"`, `$.home: missing required property "city"`},
+		},
+		{
+			name: "mistyped",
+			json: `{"name": 1, "age": 30.5, "tags": ["a", 2], "home": {"city": "Oslo"}, "level": "medium", "extra": {"a": "b"}}`,
+			want: []string{
+				`$.age: expected an integer, got a number`,
+				`$.extra.a: expected an integer, got a string`,
+				`$.level: "medium" is not one of low, high`,
+				`$.name: expected a string, got an integer`,
+				`$.tags[1]: expected a string, got an integer`,
+			},
+		},
+		{
+			name: "unexpected",
+			json: `{"name": "Ann", "age": 30, "tags": [], "home": {"city": "Oslo", "country": "NO"}, "level": "low"}`,
+			want: []string{`$.home: unexpected property "country"`},
+		},
+		{
+			name: "null optional",
+			json: `{"name": "Ann", "age": 30, "tags": [], "home": {"city": "Oslo"}, "work": null, "previous": null, "level": "low"}`,
+		},
+		{
+			// Go decodes null into a nil slice, but the schema asks the
+			// model for an array.
+			name: "null required slice",
+			json: `{"name": "Ann", "age": 30, "tags": null, "home": {"city": "Oslo"}, "level": "low"}`,
+			want: []string{`$.tags: expected an array, got null`},
+		},
+	}
+	for _, tt := range tests {
+		var v any
+		if err := json.Unmarshal([]byte(tt.json), &v); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := s.Validate(v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
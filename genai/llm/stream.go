@@ -0,0 +1,189 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/predictionguard/go-client"
+)
+
+// ErrIncomplete is returned, wrapping the error of the context, when a
+// stream is cut short because its context is done.
+var ErrIncomplete = errors.New("stream ended before the model finished")
+
+// unknown is the finish reason of a stream that ended without one. Some
+// servers leave it out, and the client ends a stream that broke or has a
+// message it could not decode quietly, so the two cannot be told apart.
+const unknown = "unknown"
+
+// streamBuffer is the number of messages the client can stream ahead of
+// the consumer. The client drops a message it cannot deliver within a few
+// seconds, so it covers a full answer.
+const streamBuffer = 1000
+
+// Event is a part of a streamed chat completion. The last event carries
+// the finish reason and the usage of the call.
+type Event struct {
+	Delta        string
+	FinishReason string
+	Usage        Usage
+}
+
+// Stream starts a streamed chat completion and returns an iterator over
+// its events. A failure to start the stream or a done context is yielded
+// as a final error. A stream that ends without a finish reason keeps its
+// answer and finishes with the reason "unknown". Stopping the iteration
+// early cancels the stream.
+//
+// The API does not report the usage of streams, so it is estimated.
+func Stream(ctx context.Context, cln Client, input client.ChatSSEInput) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		ch := make(chan client.ChatSSE, streamBuffer)
+		if err := cln.ChatSSE(ctx, input, ch); err != nil {
+			yield(Event{}, err)
+			return
+		}
+
+		// The client closes the channel once it stops, drain it so it is
+		// never left blocked when the iteration stops early.
+		defer func() {
+			cancel()
+			go func() {
+				for range ch {
+				}
+			}()
+		}()
+
+		var completion strings.Builder
+		usage := func() Usage {
+			return Usage{
+				PromptTokens:     EstimateMessagesTokens(input.Messages),
+				CompletionTokens: EstimateTokens(completion.String()),
+				Estimated:        true,
+			}
+		}
+		for resp := range ch {
+			for _, choice := range resp.Choices {
+				completion.WriteString(choice.Delta.Content)
+
+				event := Event{
+					Delta:        choice.Delta.Content,
+					FinishReason: choice.FinishReason,
+				}
+				if event.FinishReason != "" {
+					event.Usage = usage()
+				}
+
+				if event.Delta == "" && event.FinishReason == "" {
+					continue
+				}
+				if !yield(event, nil) {
+					return
+				}
+				if event.FinishReason != "" {
+					return
+				}
+			}
+		}
+
+		// The client stops quietly on a done context, so tell it apart
+		// from a stream that ended without a finish reason.
+		if err := ctx.Err(); err != nil {
+			yield(Event{}, fmt.Errorf("%w: %w", ErrIncomplete, err))
+			return
+		}
+		yield(Event{FinishReason: unknown, Usage: usage()}, nil)
+	}
+}
+
+// startStream starts a stream with a call that streams to a channel and
+// closes it once done, like the ChatSSE method of a Client, giving up on
+// the stream if it has not started within timeout. A stream that started
+// in time runs on until it ends or ctx is done: it is relayed to ch, so
+// the deadline is released with the stream rather than with ctx. A call
+// that fails leaves ch open, for the next attempt.
+func startStream(ctx context.Context, timeout time.Duration, ch chan client.ChatSSE, call func(ctx context.Context, ch chan client.ChatSSE) error) error {
+	if timeout <= 0 {
+		return call(ctx, ch)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	var mu sync.Mutex
+	started, timedOut := false, false
+	timer := time.AfterFunc(timeout, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !started {
+			timedOut = true
+			cancel()
+		}
+	})
+
+	relay := make(chan client.ChatSSE, cap(ch))
+	err := call(ctx, relay)
+
+	mu.Lock()
+	started = true
+	mu.Unlock()
+	timer.Stop()
+
+	if err != nil {
+		cancel()
+		if timedOut {
+			return fmt.Errorf("stream did not start within %s: %w", timeout, context.DeadlineExceeded)
+		}
+		return err
+	}
+
+	go func() {
+		defer cancel()
+		defer close(ch)
+
+		for resp := range relay {
+			select {
+			case ch <- resp:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Message is a streamed chat completion put back together.
+type Message struct {
+	Content      string
+	FinishReason string
+	Usage        Usage
+}
+
+// Add appends an event of a stream to the message.
+func (m *Message) Add(event Event) {
+	m.Content += event.Delta
+	if event.FinishReason != "" {
+		m.FinishReason = event.FinishReason
+		m.Usage = event.Usage
+	}
+}
+
+// Collect consumes a stream and returns the full message. On error, the
+// message holds what was received before it.
+func Collect(stream iter.Seq2[Event, error]) (Message, error) {
+	var m Message
+	for event, err := range stream {
+		if err != nil {
+			return m, err
+		}
+		m.Add(event)
+	}
+	return m, nil
+}
@@ -0,0 +1,80 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/llm/llmtest"
+	"github.com/predictionguard/go-client"
+)
+
+// unfinished streams deltas without a finish reason, and then ends when
+// its context is done, if hang is set, or right away.
+type unfinished struct {
+	*llmtest.Client
+	deltas []string
+	hang   bool
+}
+
+func (u *unfinished) ChatSSE(ctx context.Context, input client.ChatSSEInput, ch chan client.ChatSSE) error {
+	go func() {
+		defer close(ch)
+		for _, delta := range u.deltas {
+			ch <- client.ChatSSE{Choices: []client.ChatSSEChoice{{Delta: client.ChatSSEDelta{Content: delta}}}}
+		}
+		if u.hang {
+			<-ctx.Done()
+		}
+	}()
+	return nil
+}
+
+func TestStreamWithoutFinishReason(t *testing.T) {
+	fake := &unfinished{Client: &llmtest.Client{}, deltas: []string{"A goroutine ", "is a lightweight thread."}}
+
+	input := client.ChatSSEInput{
+		Model:    client.Models.Hermes2ProLlama38B,
+		Messages: []client.ChatInputMessage{{Role: client.Roles.User, Content: "What is a goroutine?"}},
+	}
+	answer, err := llm.Collect(llm.Stream(context.Background(), fake, input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Content != "A goroutine is a lightweight thread." || answer.FinishReason != "unknown" {
+		t.Errorf("got answer %q finished by %q, want the full answer finished by unknown", answer.Content, answer.FinishReason)
+	}
+	if answer.Usage.CompletionTokens == 0 {
+		t.Errorf("got no usage of the answer")
+	}
+}
+
+func TestStreamCancelled(t *testing.T) {
+	fake := &unfinished{Client: &llmtest.Client{}, deltas: []string{"A goroutine "}, hang: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	input := client.ChatSSEInput{
+		Model:    client.Models.Hermes2ProLlama38B,
+		Messages: []client.ChatInputMessage{{Role: client.Roles.User, Content: "What is a goroutine?"}},
+	}
+
+	var answer llm.Message
+	var err error
+	for event, eventErr := range llm.Stream(ctx, fake, input) {
+		if eventErr != nil {
+			err = eventErr
+			break
+		}
+		answer.Add(event)
+		cancel()
+	}
+
+	if !errors.Is(err, llm.ErrIncomplete) || !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want ErrIncomplete for a cancelled context", err)
+	}
+	if answer.Content != "A goroutine " || answer.FinishReason != "" {
+		t.Errorf("got answer %q finished by %q, want the part before the cancel", answer.Content, answer.FinishReason)
+	}
+}
@@ -0,0 +1,217 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/predictionguard/go-client"
+)
+
+// Template renders the messages of a chat into the raw prompt of a model,
+// ending where the model is to write the next assistant message.
+type Template func(messages []client.ChatInputMessage) (string, error)
+
+// Templates are the prompt formats by the name models declare them with.
+var Templates = map[string]Template{
+	"chatml":      chatML,
+	"llama3":      llama3,
+	"alpaca":      alpaca,
+	"mistral":     mistral,
+	"neural-chat": neuralChat,
+}
+
+// alpacaSystem is the preamble of Alpaca prompts without a system message.
+const alpacaSystem = "Below is an instruction that describes a task. Write a response that appropriately completes the request."
+
+// Render renders the messages of a chat in a prompt format.
+func Render(format string, messages []client.ChatInputMessage) (string, error) {
+	template, exists := Templates[format]
+	if !exists {
+		return "", fmt.Errorf("unknown prompt format %q", format)
+	}
+	if len(messages) == 0 {
+		return "", errors.New("no messages to render")
+	}
+	return template(messages)
+}
+
+// Prompt renders the messages of a chat in the prompt format of the model.
+func (s ModelSpec) Prompt(messages []client.ChatInputMessage) (string, error) {
+	if s.PromptFormat == "" {
+		return "", fmt.Errorf("model %s has no prompt format", s.Name())
+	}
+	return Render(s.PromptFormat, messages)
+}
+
+// ChatCompletion answers a chat through the completions endpoint, with
+// the messages rendered in the prompt format of the model, so models
+// without a chat endpoint answer the same prompts.
+func ChatCompletion(ctx context.Context, cln Client, spec ModelSpec, messages []client.ChatInputMessage, params Params) (string, error) {
+	if err := spec.Supports(OpCompletion); err != nil {
+		return "", err
+	}
+
+	prompt, err := spec.Prompt(messages)
+	if err != nil {
+		return "", err
+	}
+
+	input := client.CompletionInput{
+		Model:       spec.Model,
+		Prompt:      prompt,
+		MaxTokens:   params.MaxTokens,
+		Temperature: params.Temperature,
+	}
+
+	resp, err := cln.Completions(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("completions: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("completions: no choices in response")
+	}
+
+	return strings.TrimSpace(resp.Choices[0].Text), nil
+}
+
+// =============================================================================
+
+// chatML renders the format of the Hermes models:
+//
+//	<|im_start|>system
+//	...<|im_end|>
+//	<|im_start|>user
+//	...<|im_end|>
+//	<|im_start|>assistant
+func chatML(messages []client.ChatInputMessage) (string, error) {
+	var b strings.Builder
+	for _, m := range messages {
+		fmt.Fprintf(&b, "<|im_start|>%s\n%s<|im_end|>\n", m.Role, m.Content)
+	}
+	b.WriteString("<|im_start|>assistant\n")
+	return b.String(), nil
+}
+
+// llama3 renders the format of the Llama 3 models:
+//
+//	<|begin_of_text|><|start_header_id|>system<|end_header_id|>
+//
+//	...<|eot_id|><|start_header_id|>user<|end_header_id|>
+//
+//	...<|eot_id|><|start_header_id|>assistant<|end_header_id|>
+func llama3(messages []client.ChatInputMessage) (string, error) {
+	var b strings.Builder
+	b.WriteString("<|begin_of_text|>")
+	for _, m := range messages {
+		fmt.Fprintf(&b, "<|start_header_id|>%s<|end_header_id|>\n\n%s<|eot_id|>", m.Role, m.Content)
+	}
+	b.WriteString("<|start_header_id|>assistant<|end_header_id|>\n\n")
+	return b.String(), nil
+}
+
+// alpaca renders the instruction format of Alpaca and its derivatives:
+//
+//	...
+//
+//	### Instruction:
+//	...
+//
+//	### Response:
+func alpaca(messages []client.ChatInputMessage) (string, error) {
+	system, messages := splitSystem(messages)
+	if system == "" {
+		system = alpacaSystem
+	}
+
+	var b strings.Builder
+	b.WriteString(system + "\n\n")
+	for _, m := range messages {
+		switch m.Role {
+		case client.Roles.User:
+			fmt.Fprintf(&b, "### Instruction:\n%s\n\n", m.Content)
+		case client.Roles.Assistant:
+			fmt.Fprintf(&b, "### Response:\n%s\n\n", m.Content)
+		default:
+			return "", fmt.Errorf("alpaca: unexpected %s message", m.Role)
+		}
+	}
+	b.WriteString("### Response:\n")
+	return b.String(), nil
+}
+
+// mistral renders the format of the Mistral instruct models, which have
+// no system role, so the system message is put before the first user
+// message:
+//
+//	<s>[INST] ...
+//
+//	... [/INST] ...</s>[INST] ... [/INST]
+func mistral(messages []client.ChatInputMessage) (string, error) {
+	system, messages := splitSystem(messages)
+
+	var b strings.Builder
+	b.WriteString("<s>")
+	for i, m := range messages {
+		// The turns must alternate, starting and ending with the user.
+		want := client.Roles.User
+		if i%2 == 1 {
+			want = client.Roles.Assistant
+		}
+		if m.Role != want {
+			return "", fmt.Errorf("mistral: expected a %s message, got %s", want, m.Role)
+		}
+
+		switch {
+		case m.Role == client.Roles.User && i == 0 && system != "":
+			fmt.Fprintf(&b, "[INST] %s\n\n%s [/INST]", system, m.Content)
+		case m.Role == client.Roles.User:
+			fmt.Fprintf(&b, "[INST] %s [/INST]", m.Content)
+		default:
+			fmt.Fprintf(&b, " %s</s>", m.Content)
+		}
+	}
+	if len(messages)%2 == 0 {
+		return "", errors.New("mistral: the last message must be from the user")
+	}
+	return b.String(), nil
+}
+
+// neuralChat renders the format of Intel's Neural Chat models:
+//
+//	### System:
+//	...
+//	### User:
+//	...
+//	### Assistant:
+func neuralChat(messages []client.ChatInputMessage) (string, error) {
+	var b strings.Builder
+	for _, m := range messages {
+		switch m.Role {
+		case client.Roles.System:
+			fmt.Fprintf(&b, "### System:\n%s\n", m.Content)
+		case client.Roles.User:
+			fmt.Fprintf(&b, "### User:\n%s\n", m.Content)
+		case client.Roles.Assistant:
+			fmt.Fprintf(&b, "### Assistant:\n%s\n", m.Content)
+		}
+	}
+	b.WriteString("### Assistant:\n")
+	return b.String(), nil
+}
+
+// splitSystem separates the system messages of a chat, for formats that
+// have no system role.
+func splitSystem(messages []client.ChatInputMessage) (string, []client.ChatInputMessage) {
+	var system []string
+	var rest []client.ChatInputMessage
+	for _, m := range messages {
+		if m.Role == client.Roles.System {
+			system = append(system, m.Content)
+			continue
+		}
+		rest = append(rest, m)
+	}
+	return strings.Join(system, "\n\n"), rest
+}
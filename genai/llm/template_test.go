@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/predictionguard/go-client"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// chats are the chats every template is rendered with, by name.
+var chats = []struct {
+	name     string
+	messages []client.ChatInputMessage
+}{
+	{
+		name: "user",
+		messages: []client.ChatInputMessage{
+			{Role: client.Roles.User, Content: "What is a goroutine?"},
+		},
+	},
+	{
+		name: "system",
+		messages: []client.ChatInputMessage{
+			{Role: client.Roles.System, Content: "You are a helpful Go assistant."},
+			{Role: client.Roles.User, Content: "What is a goroutine?"},
+		},
+	},
+	{
+		name: "multi-turn",
+		messages: []client.ChatInputMessage{
+			{Role: client.Roles.System, Content: "You are a helpful Go assistant."},
+			{Role: client.Roles.User, Content: "What is a goroutine?"},
+			{Role: client.Roles.Assistant, Content: "A function running concurrently with others."},
+			{Role: client.Roles.User, Content: "How do goroutines talk to each other?"},
+		},
+	},
+}
+
+func TestTemplates(t *testing.T) {
+	for format := range Templates {
+		for _, chat := range chats {
+			t.Run(format+"/"+chat.name, func(t *testing.T) {
+				got, err := Render(format, chat.messages)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				golden := filepath.Join("testdata", "templates", format+"-"+chat.name+".golden")
+				if *update {
+					if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != string(want) {
+					t.Errorf("got prompt\n%s\nwant\n%s", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	tests := []struct {
+		format   string
+		messages []client.ChatInputMessage
+	}{
+		{format: "unknown", messages: chats[0].messages},
+		{format: "chatml"},
+		{
+			format: "mistral",
+			messages: []client.ChatInputMessage{
+				{Role: client.Roles.User, Content: "What is a goroutine?"},
+				{Role: client.Roles.Assistant, Content: "A function running concurrently with others."},
+			},
+		},
+		{
+			format: "mistral",
+			messages: []client.ChatInputMessage{
+				{Role: client.Roles.User, Content: "What is a goroutine?"},
+				{Role: client.Roles.User, Content: "How do goroutines talk to each other?"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if _, err := Render(tt.format, tt.messages); err == nil {
+			t.Errorf("Render(%q) of %d messages succeeded, want an error", tt.format, len(tt.messages))
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"fmt"
+
+	"github.com/predictionguard/go-client"
+)
+
+// Usage counts the tokens of a call. The API does not report usage for
+// every call, in which case it is estimated locally and Estimated is set.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	Estimated        bool
+}
+
+// TotalTokens returns the number of prompt and completion tokens.
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// Add returns the sum of two usages, which is estimated if either is.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		Estimated:        u.Estimated || other.Estimated,
+	}
+}
+
+// String implements the fmt.Stringer interface.
+func (u Usage) String() string {
+	s := fmt.Sprintf("%d prompt + %d completion tokens", u.PromptTokens, u.CompletionTokens)
+	if u.Estimated {
+		s += " (estimated)"
+	}
+	return s
+}
+
+// EstimateTokens estimates the number of tokens of a text with the rule
+// of thumb of four characters per token.
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// EstimateMessagesTokens estimates the number of prompt tokens of a chat,
+// counting a few tokens per message for the role and delimiters of the
+// prompt format.
+func EstimateMessagesTokens(messages []client.ChatInputMessage) int {
+	var tokens int
+	for _, m := range messages {
+		tokens += EstimateTokens(m.Content) + 4
+	}
+	return tokens
+}
@@ -0,0 +1,445 @@
+// Package longdoc summarizes documents, and answers questions about them,
+// when they are too long for a single prompt. A document is split into
+// pieces that fit the context of the model, and either the model is
+// mapped over the pieces concurrently and its notes are reduced into one
+// answer, or the model refines an answer piece by piece.
+package longdoc
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/dwhitena/go-genai-webinar/genai/chain"
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/predictionguard/go-client"
+)
+
+// Names of the prompts a chain uses by default, all with the variable
+// question, empty for a summary: the notes on a piece of the document,
+// with the variable text; the reduction of notes, with the variables
+// notes and final, false when notes are combined into fewer notes; and
+// the refinement of an answer, with the variables answer and text.
+const (
+	MapPrompt    = "map"
+	ReducePrompt = "reduce"
+	RefinePrompt = "refine"
+)
+
+// none is what the model responds with when a piece does not help answer
+// the question.
+const none = "none"
+
+// minPiece is the smallest piece, in tokens, a budget has to leave room
+// for.
+const minPiece = 100
+
+// ErrEmpty is returned for the summary of a document without any text. A
+// question about such a document is refused instead.
+var ErrEmpty = errors.New("empty document")
+
+// Chain summarizes, or answers questions about, documents too long for a
+// single prompt with the Model. The prompts are taken from Prompts, the
+// default ones if nil, by the names in Mapping, Reduction and Refinement,
+// MapPrompt, ReducePrompt and RefinePrompt if empty. Every prompt is kept
+// within the Budget of tokens, by default the context length of the model
+// less the MaxTokens of an answer. The Temperature is sent as is, like the
+// one of a rag.RAG, so a temperature of 0 can be asked for. At most
+// Parallel pieces are mapped at once, and the progress is written to
+// Progress, if set.
+type Chain struct {
+	Client      llm.Client
+	Model       llm.ModelSpec
+	Prompts     *prompt.Set
+	Mapping     string
+	Reduction   string
+	Refinement  string
+	Budget      int
+	MaxTokens   int
+	Temperature float32
+	Parallel    int
+	Progress    io.Writer
+}
+
+// Result is the summary of a document or the answer to a question about
+// it, with the number of pieces the document was split into and of calls
+// to the model. An answer is Refused when no piece helps answer the
+// question.
+type Result struct {
+	Answer  string
+	Refused bool
+	Pieces  int
+	Calls   int
+	Usage   llm.Usage
+}
+
+// logf writes progress information when a writer is provided.
+func (c Chain) logf(format string, v ...any) {
+	if c.Progress != nil {
+		fmt.Fprintf(c.Progress, format, v...)
+	}
+}
+
+// MapReduce summarizes a document, or answers a question about it. The
+// notes of the model on every piece are combined into fewer notes until
+// they fit a prompt, and then into the summary or the answer.
+func (c Chain) MapReduce(ctx context.Context, document string, question string) (Result, error) {
+	if err := c.Model.Supports(llm.OpChat); err != nil {
+		return Result{}, err
+	}
+
+	size, err := c.pieceSize(cmp.Or(c.Mapping, MapPrompt), map[string]any{"text": "", "question": question})
+	if err != nil {
+		return Result{}, err
+	}
+	pieces := Split(document, size)
+	if len(pieces) == 0 && question == "" {
+		return Result{}, ErrEmpty
+	}
+
+	var result Result
+	result.Pieces = len(pieces)
+	c.logf("Split the document into %d pieces of up to %d tokens\n", len(pieces), size)
+
+	notes, err := c.mapPieces(ctx, pieces, question, &result)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(notes) == 0 {
+		return c.refuse(result)
+	}
+
+	final := func(notes []string) map[string]any {
+		return map[string]any{"notes": notes, "question": question, "final": true}
+	}
+	for {
+		fits, err := c.fits(cmp.Or(c.Reduction, ReducePrompt), final(notes))
+		if err != nil {
+			return Result{}, err
+		}
+		if fits || len(notes) == 1 {
+			break
+		}
+
+		collapsed, err := c.collapse(ctx, notes, question, &result)
+		if err != nil {
+			return Result{}, err
+		}
+		if len(collapsed) >= len(notes) {
+			return Result{}, fmt.Errorf("%d notes do not fit a budget of %d tokens", len(notes), c.budget())
+		}
+		notes = collapsed
+	}
+
+	c.logf("Reducing %d notes\n", len(notes))
+	answer, usage, err := c.call(ctx, cmp.Or(c.Reduction, ReducePrompt), final(notes))
+	if err != nil {
+		return Result{}, fmt.Errorf("reduce: %w", err)
+	}
+	result.Answer = answer
+	result.Calls++
+	result.Usage = result.Usage.Add(usage)
+
+	return result, nil
+}
+
+// note is the notes of the model on a piece, and the usage of the call.
+type note struct {
+	text  string
+	usage llm.Usage
+}
+
+// mapPieces takes notes on the pieces concurrently, in order, leaving out
+// the pieces that do not help answer the question.
+func (c Chain) mapPieces(ctx context.Context, pieces []string, question string, result *Result) ([]string, error) {
+	var mu sync.Mutex
+	var done int
+	take := func(ctx context.Context, piece string) (note, error) {
+		text, usage, err := c.call(ctx, cmp.Or(c.Mapping, MapPrompt), map[string]any{"text": piece, "question": question})
+		if err != nil {
+			return note{}, err
+		}
+
+		mu.Lock()
+		done++
+		c.logf("Mapped piece %d of %d\n", done, len(pieces))
+		mu.Unlock()
+
+		return note{text: text, usage: usage}, nil
+	}
+
+	notes, err := chain.Named("map", chain.Map(c.Parallel, take))(ctx, pieces)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []string
+	for _, n := range notes {
+		result.Calls++
+		result.Usage = result.Usage.Add(n.usage)
+		if question != "" && llm.NormalizeAnswer(n.text) == none {
+			continue
+		}
+		kept = append(kept, n.text)
+	}
+
+	return kept, nil
+}
+
+// collapse combines consecutive notes that fit a prompt together into one
+// note each.
+func (c Chain) collapse(ctx context.Context, notes []string, question string, result *Result) ([]string, error) {
+	name := cmp.Or(c.Reduction, ReducePrompt)
+	vars := func(notes []string) map[string]any {
+		return map[string]any{"notes": notes, "question": question, "final": false}
+	}
+
+	var groups [][]string
+	var group []string
+	for _, note := range notes {
+		fits, err := c.fits(name, vars(append(group, note)))
+		if err != nil {
+			return nil, err
+		}
+		if !fits && len(group) > 0 {
+			groups = append(groups, group)
+			group = nil
+		}
+		group = append(group, note)
+	}
+	groups = append(groups, group)
+
+	c.logf("Combining %d notes into %d\n", len(notes), len(groups))
+
+	collapsed := make([]string, len(groups))
+	for i, group := range groups {
+		if len(group) == 1 {
+			collapsed[i] = group[0]
+			continue
+		}
+
+		note, usage, err := c.call(ctx, name, vars(group))
+		if err != nil {
+			return nil, fmt.Errorf("reduce: %w", err)
+		}
+		collapsed[i] = note
+		result.Calls++
+		result.Usage = result.Usage.Add(usage)
+	}
+
+	return collapsed, nil
+}
+
+// Refine summarizes a document, or answers a question about it, by having
+// the model refine its answer with one piece after the other. It makes a
+// call per piece, none in parallel, but the answer takes every piece into
+// account in its context.
+func (c Chain) Refine(ctx context.Context, document string, question string) (Result, error) {
+	if err := c.Model.Supports(llm.OpChat); err != nil {
+		return Result{}, err
+	}
+
+	// The answer so far takes up to MaxTokens of the prompt as well.
+	name := cmp.Or(c.Refinement, RefinePrompt)
+	size, err := c.pieceSize(name, map[string]any{"answer": strings.Repeat("word ", c.maxTokens()), "text": "", "question": question})
+	if err != nil {
+		return Result{}, err
+	}
+	pieces := Split(document, size)
+	if len(pieces) == 0 && question == "" {
+		return Result{}, ErrEmpty
+	}
+
+	var result Result
+	result.Pieces = len(pieces)
+	c.logf("Split the document into %d pieces of up to %d tokens\n", len(pieces), size)
+
+	var answer string
+	for i, piece := range pieces {
+		refined, usage, err := c.call(ctx, name, map[string]any{"answer": answer, "text": piece, "question": question})
+		if err != nil {
+			return Result{}, fmt.Errorf("refine piece %d: %w", i+1, err)
+		}
+		result.Calls++
+		result.Usage = result.Usage.Add(usage)
+		c.logf("Refined with piece %d of %d\n", i+1, len(pieces))
+
+		if question != "" && llm.NormalizeAnswer(refined) == none {
+			continue
+		}
+		answer = refined
+	}
+
+	if answer == "" {
+		return c.refuse(result)
+	}
+	result.Answer = answer
+
+	return result, nil
+}
+
+// refinePieces is the largest number of pieces a document is refined
+// piece by piece in by Summarize.
+const refinePieces = 3
+
+// Summarize is the step that summarizes a document, or answers the
+// question about it. A document of a few pieces is refined piece by piece,
+// for an answer that takes each of them into account; a longer one is
+// mapped and reduced, so its pieces are mapped in parallel.
+func (c Chain) Summarize(question string) chain.Step[string, Result] {
+	few := func(ctx context.Context, document string) bool {
+		size, err := c.pieceSize(cmp.Or(c.Mapping, MapPrompt), map[string]any{"text": "", "question": question})
+		if err != nil {
+			// MapReduce reports the error.
+			return false
+		}
+		return len(Split(document, size)) <= refinePieces
+	}
+
+	return chain.Named("summarize", chain.Branch(few,
+		chain.Named("refine", func(ctx context.Context, document string) (Result, error) {
+			return c.Refine(ctx, document, question)
+		}),
+		chain.Named("map-reduce", func(ctx context.Context, document string) (Result, error) {
+			return c.MapReduce(ctx, document, question)
+		}),
+	))
+}
+
+// refuse answers with the refusal of the prompts, when no piece helps
+// answer the question.
+func (c Chain) refuse(result Result) (Result, error) {
+	refusal, err := c.prompts().Render("refusal", nil)
+	if err != nil {
+		return Result{}, err
+	}
+	result.Answer = refusal
+	result.Refused = true
+	return result, nil
+}
+
+// prompts returns the prompts of the chain.
+func (c Chain) prompts() *prompt.Set {
+	if c.Prompts == nil {
+		return prompt.Default()
+	}
+	return c.Prompts
+}
+
+// maxTokens returns the maximum number of tokens of an answer.
+func (c Chain) maxTokens() int {
+	return cmp.Or(c.MaxTokens, c.Model.Defaults.MaxTokens, 500)
+}
+
+// budget returns the maximum number of tokens of a prompt.
+func (c Chain) budget() int {
+	if c.Budget > 0 {
+		return c.Budget
+	}
+	if c.Model.ContextLength > 0 {
+		return c.Model.ContextLength - c.maxTokens()
+	}
+	return 2048
+}
+
+// pieceSize returns the number of tokens of the document that fit a
+// prompt rendered with the variables, with the text left empty.
+func (c Chain) pieceSize(name string, vars map[string]any) (int, error) {
+	content, err := c.prompts().Render(name, vars)
+	if err != nil {
+		return 0, err
+	}
+
+	size := c.budget() - llm.EstimateMessagesTokens([]client.ChatInputMessage{{Content: content}})
+	if size < minPiece {
+		return 0, fmt.Errorf("budget of %d tokens leaves no room for the document in prompt %s", c.budget(), name)
+	}
+	return size, nil
+}
+
+// fits reports whether a prompt rendered with the variables fits the
+// budget.
+func (c Chain) fits(name string, vars map[string]any) (bool, error) {
+	content, err := c.prompts().Render(name, vars)
+	if err != nil {
+		return false, err
+	}
+	return llm.EstimateMessagesTokens([]client.ChatInputMessage{{Content: content}}) <= c.budget(), nil
+}
+
+// call renders a prompt and returns the answer of the model to it.
+func (c Chain) call(ctx context.Context, name string, vars map[string]any) (string, llm.Usage, error) {
+	content, err := c.prompts().Render(name, vars)
+	if err != nil {
+		return "", llm.Usage{}, err
+	}
+
+	input := client.ChatInput{
+		Model: c.Model.Model,
+		Messages: []client.ChatInputMessage{
+			{Role: client.Roles.User, Content: content},
+		},
+		MaxTokens:   c.maxTokens(),
+		Temperature: c.Temperature,
+	}
+	resp, err := c.Client.Chat(ctx, input)
+	if err != nil {
+		return "", llm.Usage{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return "", llm.Usage{}, errors.New("no choices in response")
+	}
+	answer := strings.TrimSpace(resp.Choices[0].Message.Content)
+
+	usage := llm.Usage{
+		PromptTokens:     llm.EstimateMessagesTokens(input.Messages),
+		CompletionTokens: llm.EstimateTokens(answer),
+		Estimated:        true,
+	}
+	return answer, usage, nil
+}
+
+// Split splits a text into pieces of at most the given number of tokens,
+// estimated. Paragraphs are kept together where they fit, and longer ones
+// are split between words.
+func Split(text string, tokens int) []string {
+	var pieces []string
+	var piece []string
+	var size int
+	add := func(part string, sep string) {
+		n := llm.EstimateTokens(part + sep)
+		if size+n > tokens && len(piece) > 0 {
+			pieces = append(pieces, strings.TrimSpace(strings.Join(piece, "")))
+			piece, size = nil, 0
+		}
+		piece = append(piece, part+sep)
+		size += n
+	}
+
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if llm.EstimateTokens(paragraph) <= tokens {
+			add(paragraph, "\n\n")
+			continue
+		}
+		for _, word := range strings.Fields(paragraph) {
+			add(word, " ")
+		}
+		add("", "\n\n")
+	}
+	if len(piece) > 0 {
+		if last := strings.TrimSpace(strings.Join(piece, "")); last != "" {
+			pieces = append(pieces, last)
+		}
+	}
+
+	return pieces
+}
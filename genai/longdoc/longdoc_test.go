@@ -0,0 +1,173 @@
+package longdoc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/llm/llmtest"
+	"github.com/dwhitena/go-genai-webinar/genai/longdoc"
+	"github.com/predictionguard/go-client"
+)
+
+var spec = llm.DefaultRegistry["Hermes-2-Pro-Llama-3-8B"]
+
+// document returns a document of paragraphs that each fill about a piece
+// of a budget of 300 tokens, and start with their number.
+func document(paragraphs int) string {
+	var doc []string
+	for i := 1; i <= paragraphs; i++ {
+		doc = append(doc, fmt.Sprintf("piece%d ", i)+strings.Repeat("lorem ipsum dolor sit amet ", 30))
+	}
+	return strings.Join(doc, "\n\n")
+}
+
+func TestSplit(t *testing.T) {
+	long := strings.Repeat("word ", 100)
+	text := "A short paragraph.\n\nAnother short one.\r\n\r\n" + long + "\n\nThe end."
+
+	pieces := longdoc.Split(text, 40)
+	for i, piece := range pieces {
+		if n := llm.EstimateTokens(piece); n > 40 {
+			t.Errorf("piece %d has %d tokens, want at most 40", i+1, n)
+		}
+	}
+	if !strings.HasPrefix(pieces[0], "A short paragraph.\n\nAnother short one.\n\nword") {
+		t.Errorf("got first piece %q, want the short paragraphs together", pieces[0])
+	}
+	if got, want := strings.Fields(strings.Join(pieces, " ")), strings.Fields(text); !slices.Equal(got, want) {
+		t.Errorf("got words %q, want %q", got, want)
+	}
+
+	if pieces := longdoc.Split(" \n\n \n\n", 40); len(pieces) != 0 {
+		t.Errorf("got pieces %q of a blank text, want none", pieces)
+	}
+}
+
+// number is the number of the piece a map prompt is about.
+var number = regexp.MustCompile(`piece(\d+)`)
+
+func TestMapReduce(t *testing.T) {
+	// The first pieces are mapped last, yet their notes are reduced in
+	// the order of the pieces.
+	var reduced string
+	fake := &llmtest.Client{
+		Answer: func(ctx context.Context, messages []client.ChatInputMessage) (string, error) {
+			content := messages[0].Content
+			if strings.HasPrefix(content, "Below are") {
+				reduced = content
+				return "summary", nil
+			}
+			m := number.FindStringSubmatch(content)
+			if m == nil {
+				return "", errors.New("no piece in map prompt")
+			}
+			n, _ := strconv.Atoi(m[1])
+			time.Sleep(time.Duration(6-n) * 10 * time.Millisecond)
+			return "note" + m[1], nil
+		},
+	}
+
+	c := longdoc.Chain{Client: fake, Model: spec, Budget: 300, Parallel: 5}
+	result, err := c.MapReduce(context.Background(), document(5), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Answer != "summary" || result.Pieces != 5 || result.Calls != 6 {
+		t.Errorf("got %+v, want a summary of 5 pieces in 6 calls", result)
+	}
+	for _, call := range fake.CallsOf(llm.OpChat) {
+		if n := llm.EstimateMessagesTokens(call.Messages); n > 300 {
+			t.Errorf("got a prompt of %d tokens, want at most 300", n)
+		}
+	}
+	var last int
+	for i := 1; i <= 5; i++ {
+		at := strings.Index(reduced, fmt.Sprintf("note%d", i))
+		if at < last {
+			t.Fatalf("note %d is out of order in the reduce prompt:\n%s", i, reduced)
+		}
+		last = at
+	}
+}
+
+func TestMapReduceRefusal(t *testing.T) {
+	fake := &llmtest.Client{Answers: []string{"NONE"}}
+
+	c := longdoc.Chain{Client: fake, Model: spec, Budget: 300}
+	result, err := c.MapReduce(context.Background(), document(3), "What is Go?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Refused || result.Calls != 3 {
+		t.Errorf("got %+v, want a refusal after mapping 3 pieces", result)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	c := longdoc.Chain{Client: &llmtest.Client{}, Model: spec}
+
+	if _, err := c.MapReduce(context.Background(), " \n\n ", ""); !errors.Is(err, longdoc.ErrEmpty) {
+		t.Errorf("got error %v of a map-reduce summary, want ErrEmpty", err)
+	}
+	if _, err := c.Refine(context.Background(), "", ""); !errors.Is(err, longdoc.ErrEmpty) {
+		t.Errorf("got error %v of a refined summary, want ErrEmpty", err)
+	}
+
+	result, err := c.MapReduce(context.Background(), "", "What is Go?")
+	if err != nil || !result.Refused {
+		t.Errorf("got %+v, %v for a question, want a refusal", result, err)
+	}
+}
+
+func TestRefine(t *testing.T) {
+	fake := &llmtest.Client{Answers: []string{"first", "NONE", "third"}}
+
+	c := longdoc.Chain{Client: fake, Model: spec, Budget: 400, MaxTokens: 50}
+	result, err := c.Refine(context.Background(), document(3), "What is Go?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Answer != "third" || result.Pieces != 3 || result.Calls != 3 {
+		t.Errorf("got %+v, want the answer refined with 3 pieces", result)
+	}
+
+	// The answer so far is refined with the next piece, unless that piece
+	// did not help.
+	calls := fake.CallsOf(llm.OpChat)
+	if !strings.Contains(calls[2].Messages[0].Content, "first") {
+		t.Errorf("the last piece was not refined with the answer of the first")
+	}
+}
+
+// temperatures records the temperatures of the chat calls of a fake.
+type temperatures struct {
+	*llmtest.Client
+	got []float32
+}
+
+func (t *temperatures) Chat(ctx context.Context, input client.ChatInput) (client.Chat, error) {
+	t.got = append(t.got, input.Temperature)
+	return t.Client.Chat(ctx, input)
+}
+
+func TestTemperature(t *testing.T) {
+	fake := &temperatures{Client: &llmtest.Client{Answers: []string{"summary"}}}
+
+	// A temperature of 0 is not replaced by the default of the model.
+	c := longdoc.Chain{Client: fake, Model: spec, Temperature: 0}
+	if _, err := c.Refine(context.Background(), "A short document.", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(fake.got, []float32{0}) {
+		t.Errorf("got temperatures %v, want [0]", fake.got)
+	}
+}
@@ -0,0 +1,247 @@
+// Package memory keeps the history of a conversation within the context
+// of a model. A conversation that keeps every turn grows without bound
+// and eventually fails, so a memory keeps the latest turns, the turns
+// that fit a budget of tokens, a summary of the turns written by the
+// model, or a summary of the older turns and the latest ones as they are.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/predictionguard/go-client"
+)
+
+// Names of the prompts a Summary uses by default: the summary of the
+// older turns of a conversation, with the variables summary and turns,
+// and the message that recalls it to the model, with the variable
+// summary.
+const (
+	MemoryPrompt = "memory"
+	RecapPrompt  = "recap"
+)
+
+// Turn is a question of the user and the answer of the assistant, as
+// they were asked and answered, without the context retrieved for them.
+type Turn struct {
+	Question string
+	Answer   string
+}
+
+// Messages returns the user and assistant messages of the turns.
+func Messages(turns []Turn) []client.ChatInputMessage {
+	messages := make([]client.ChatInputMessage, 0, 2*len(turns))
+	for _, turn := range turns {
+		messages = append(messages,
+			client.ChatInputMessage{Role: client.Roles.User, Content: turn.Question},
+			client.ChatInputMessage{Role: client.Roles.Assistant, Content: turn.Answer},
+		)
+	}
+	return messages
+}
+
+// Memory keeps the turns of a conversation and recalls its history as the
+// messages to send before the next question. Implementations are safe for
+// concurrent use.
+type Memory interface {
+	Add(ctx context.Context, turn Turn) error
+	Messages() []client.ChatInputMessage
+}
+
+// =============================================================================
+
+// Window is a memory of the latest Turns of a conversation, all of them
+// if Turns is 0.
+type Window struct {
+	Turns int
+
+	mu    sync.Mutex
+	turns []Turn
+}
+
+// Add implements the Memory interface.
+func (w *Window) Add(ctx context.Context, turn Turn) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.turns = append(w.turns, turn)
+	if w.Turns > 0 && len(w.turns) > w.Turns {
+		w.turns = w.turns[len(w.turns)-w.Turns:]
+	}
+	return nil
+}
+
+// Messages implements the Memory interface.
+func (w *Window) Messages() []client.ChatInputMessage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return Messages(w.turns)
+}
+
+// =============================================================================
+
+// TokenBuffer is a memory of the latest turns of a conversation that fit
+// a Budget of tokens, estimated. A turn that does not fit on its own is
+// forgotten.
+type TokenBuffer struct {
+	Budget int
+
+	mu    sync.Mutex
+	turns []Turn
+}
+
+// Add implements the Memory interface.
+func (b *TokenBuffer) Add(ctx context.Context, turn Turn) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.turns = append(b.turns, turn)
+	b.turns = b.turns[fit(b.turns, b.Budget):]
+	return nil
+}
+
+// Messages implements the Memory interface.
+func (b *TokenBuffer) Messages() []client.ChatInputMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return Messages(b.turns)
+}
+
+// fit returns the index of the first of the latest turns whose messages
+// fit a budget of tokens.
+func fit(turns []Turn, budget int) int {
+	var tokens int
+	for i := len(turns) - 1; i >= 0; i-- {
+		tokens += llm.EstimateMessagesTokens(Messages(turns[i : i+1]))
+		if tokens > budget {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// =============================================================================
+
+// Summary is a memory of the latest turns of a conversation that fit a
+// Budget of tokens, estimated, and of a rolling summary of the turns
+// before them, written by the Model. With no Budget, every turn is folded
+// into the summary as it is added. The prompts are taken from Prompts, the
+// default ones if nil, by the names in Fold and Recap, MemoryPrompt and
+// RecapPrompt if empty.
+type Summary struct {
+	Client    llm.Client
+	Model     llm.ModelSpec
+	Prompts   *prompt.Set
+	Fold      string
+	Recap     string
+	Budget    int
+	MaxTokens int
+
+	mu      sync.Mutex
+	summary string
+	turns   []Turn
+}
+
+// Add implements the Memory interface. The turns that no longer fit the
+// budget are folded into the summary, and kept if that fails, to be
+// folded with the next turn.
+func (s *Summary) Add(ctx context.Context, turn Turn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.turns = append(s.turns, turn)
+	older := fit(s.turns, s.Budget)
+	if older == 0 {
+		return nil
+	}
+
+	summary, err := s.fold(ctx, s.turns[:older])
+	if err != nil {
+		return fmt.Errorf("memory: %w", err)
+	}
+	s.summary = summary
+	s.turns = s.turns[older:]
+
+	return nil
+}
+
+// Messages implements the Memory interface. The summary, if any, is
+// recalled in a system message before the latest turns.
+func (s *Summary) Messages() []client.ChatInputMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []client.ChatInputMessage
+	if s.summary != "" {
+		recap, err := s.prompts().Render(cmp.Or(s.Recap, RecapPrompt), map[string]any{"summary": s.summary})
+		if err != nil {
+			// The recap is checked when the summary is written.
+			recap = s.summary
+		}
+		messages = append(messages, client.ChatInputMessage{Role: client.Roles.System, Content: recap})
+	}
+	return append(messages, Messages(s.turns)...)
+}
+
+// Summary returns the summary of the older turns, empty until a turn is
+// folded into it.
+func (s *Summary) Summary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.summary
+}
+
+// prompts returns the prompts of the memory.
+func (s *Summary) prompts() *prompt.Set {
+	if s.Prompts == nil {
+		return prompt.Default()
+	}
+	return s.Prompts
+}
+
+// fold has the model write the summary with the turns folded into it.
+func (s *Summary) fold(ctx context.Context, turns []Turn) (string, error) {
+	prompts := s.prompts()
+	if _, err := prompts.Get(cmp.Or(s.Recap, RecapPrompt)); err != nil {
+		return "", err
+	}
+
+	content, err := prompts.Render(cmp.Or(s.Fold, MemoryPrompt), map[string]any{
+		"summary": s.summary,
+		"turns":   turns,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	input := client.ChatInput{
+		Model: s.Model.Model,
+		Messages: []client.ChatInputMessage{
+			{Role: client.Roles.User, Content: content},
+		},
+		MaxTokens:   cmp.Or(s.MaxTokens, 300),
+		Temperature: 0.1,
+	}
+	resp, err := s.Client.Chat(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("no choices in response")
+	}
+
+	summary := strings.TrimSpace(resp.Choices[0].Message.Content)
+	if summary == "" {
+		return "", errors.New("empty summary")
+	}
+	return summary, nil
+}
@@ -0,0 +1,129 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/llm/llmtest"
+	"github.com/dwhitena/go-genai-webinar/genai/memory"
+	"github.com/predictionguard/go-client"
+)
+
+// turn returns the n-th turn of a conversation, of 28 tokens, estimated.
+func turn(n string) memory.Turn {
+	return memory.Turn{
+		Question: "Question " + n + strings.Repeat(".", 30),
+		Answer:   "Answer " + n + strings.Repeat(".", 32),
+	}
+}
+
+// questions returns the questions of the user messages.
+func questions(messages []client.ChatInputMessage) []string {
+	var qs []string
+	for _, m := range messages {
+		if m.Role == client.Roles.User {
+			qs = append(qs, strings.TrimRight(m.Content, "."))
+		}
+	}
+	return qs
+}
+
+func TestWindow(t *testing.T) {
+	tests := []struct {
+		turns int
+		want  []string
+	}{
+		{turns: 2, want: []string{"Question 2", "Question 3"}},
+		{turns: 0, want: []string{"Question 1", "Question 2", "Question 3"}},
+	}
+
+	for _, tt := range tests {
+		w := &memory.Window{Turns: tt.turns}
+		for _, n := range []string{"1", "2", "3"} {
+			if err := w.Add(context.Background(), turn(n)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := questions(w.Messages()); !slices.Equal(got, tt.want) {
+			t.Errorf("got %q of a window of %d turns, want %q", got, tt.turns, tt.want)
+		}
+	}
+}
+
+func TestTokenBuffer(t *testing.T) {
+	if n := llm.EstimateMessagesTokens(memory.Messages([]memory.Turn{turn("1")})); n != 28 {
+		t.Fatalf("got a turn of %d tokens, want 28", n)
+	}
+
+	b := &memory.TokenBuffer{Budget: 60}
+	for _, n := range []string{"1", "2", "3"} {
+		if err := b.Add(context.Background(), turn(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := questions(b.Messages()), []string{"Question 2", "Question 3"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want the turns that fit %q", got, want)
+	}
+
+	// A turn that does not fit on its own is forgotten, with the others.
+	long := memory.Turn{Question: strings.Repeat("word ", 100), Answer: "Yes."}
+	if err := b.Add(context.Background(), long); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Messages(); len(got) != 0 {
+		t.Errorf("got %d messages, want none", len(got))
+	}
+}
+
+func TestSummary(t *testing.T) {
+	fake := &llmtest.Client{Answers: []string{"The user asked about 1.", "The user asked about 1, 2 and 3."}}
+	s := &memory.Summary{Client: fake, Model: llm.DefaultRegistry[llm.DefaultModel], Budget: 30}
+
+	// The first turn fits the budget, the second one pushes it out.
+	for _, n := range []string{"1", "2"} {
+		if err := s.Add(context.Background(), turn(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Summary() != "The user asked about 1." {
+		t.Errorf("got summary %q, want the first turn folded", s.Summary())
+	}
+	messages := s.Messages()
+	if len(messages) != 3 || messages[0].Role != client.Roles.System || !strings.Contains(messages[0].Content, "The user asked about 1.") {
+		t.Errorf("got messages %+v, want the summary recalled before the second turn", messages)
+	}
+
+	// A turn that fails to fold is kept, and folded with the next one.
+	fake.Err = errors.New("service unavailable")
+	if err := s.Add(context.Background(), turn("3")); err == nil {
+		t.Fatal("got no error, want the fold to fail")
+	}
+	if s.Summary() != "The user asked about 1." {
+		t.Errorf("got summary %q, want it unchanged", s.Summary())
+	}
+	if got, want := questions(s.Messages()), []string{"Question 2", "Question 3"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want the turns that failed to fold kept %q", got, want)
+	}
+
+	fake.Err = nil
+	if err := s.Add(context.Background(), turn("4")); err != nil {
+		t.Fatal(err)
+	}
+	if s.Summary() != "The user asked about 1, 2 and 3." {
+		t.Errorf("got summary %q, want the kept turns folded", s.Summary())
+	}
+	calls := fake.CallsOf(llm.OpChat)
+	fold := calls[len(calls)-1].Messages[0].Content
+	for _, want := range []string{"The user asked about 1.", "Question 2", "Question 3"} {
+		if !strings.Contains(fold, want) {
+			t.Errorf("fold prompt does not contain %q:\n%s", want, fold)
+		}
+	}
+	if got, want := questions(s.Messages()), []string{"Question 4"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,362 @@
+// Package prompt loads prompts from template files, so they can be
+// changed without recompiling.
+//
+// A prompt is a text/template file named after the prompt and its version,
+// like qa.v2.tmpl, and prompts are referred to by name for their latest
+// version or by name and version for a given one, like "qa" or "qa.v1".
+// Files whose names start with an underscore hold partials, templates
+// defined with {{define}} that every prompt can include with {{template}}.
+//
+// A prompt file can start with a header of "key: value" lines between
+// "---" lines. The keys are description and required, the comma-separated
+// variables a prompt cannot be rendered without:
+//
+//	---
+//	description: Asks a question about a context.
+//	required: context, question
+//	---
+//	{{delimit "context" .context}}
+//
+// Prompts are rendered with their variables by name. Text from outside,
+// like retrieved context, should be inserted with delimit, which wraps it
+// in tags it cannot close itself, or quote, which escapes it as a JSON
+// string.
+package prompt
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Env is the environment variable of a directory of prompts that add to
+// or replace the default ones.
+const Env = "GENAI_PROMPTS"
+
+//go:embed prompts/*.tmpl
+var defaults embed.FS
+
+// Default returns the prompts shipped with the package.
+func Default() *Set {
+	return defaultSet()
+}
+
+var defaultSet = sync.OnceValue(func() *Set {
+	return Must(FromDir(""))
+})
+
+// FromDir returns the default prompts with the ones of a directory added
+// or replaced, file by file. An empty dir returns the default prompts.
+func FromDir(dir string) (*Set, error) {
+	fsys, err := fs.Sub(defaults, "prompts")
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return Load(fsys)
+	}
+	return Load(fsys, os.DirFS(dir))
+}
+
+// FromEnv returns the default prompts with the ones of the directory in
+// $GENAI_PROMPTS, if any, added or replaced.
+func FromEnv() (*Set, error) {
+	return FromDir(os.Getenv(Env))
+}
+
+// Configured returns the prompts of FromEnv, loaded on first use, for
+// programs that take their prompts from the environment. It panics if they
+// do not load.
+func Configured() *Set {
+	return configuredSet()
+}
+
+var configuredSet = sync.OnceValue(func() *Set {
+	return Must(FromEnv())
+})
+
+// Prompt is a version of a prompt.
+type Prompt struct {
+	Name        string
+	Version     int
+	Description string
+	Required    []string
+	tmpl        *template.Template
+}
+
+// ID returns the name and version of the prompt, like "qa.v2".
+func (p *Prompt) ID() string {
+	return fmt.Sprintf("%s.v%d", p.Name, p.Version)
+}
+
+// Render executes the prompt with its variables, after checking that the
+// required ones are set. Leading and trailing whitespace is trimmed.
+func (p *Prompt) Render(vars map[string]any) (string, error) {
+	var missing []string
+	for _, name := range p.Required {
+		if _, exists := vars[name]; !exists {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("prompt %s: missing variables %s", p.ID(), strings.Join(missing, ", "))
+	}
+
+	var b bytes.Buffer
+	if err := p.tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("prompt %s: %w", p.ID(), err)
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+// Set is a set of prompts by name and version.
+type Set struct {
+	prompts map[string][]*Prompt
+}
+
+// fileName matches the name of a prompt file, with an optional version.
+var fileName = regexp.MustCompile(`^([A-Za-z0-9_-]+?)(?:\.v([0-9]+))?\.tmpl$`)
+
+// Load parses the prompts of the *.tmpl files at the root of the file
+// systems. A file replaces the one of the same name in the file systems
+// before it.
+func Load(fsyss ...fs.FS) (*Set, error) {
+	files := map[string]string{}
+	for _, fsys := range fsyss {
+		names, err := fs.Glob(fsys, "*.tmpl")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				return nil, err
+			}
+			files[name] = string(data)
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var partials []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "_") {
+			partials = append(partials, name)
+		}
+	}
+
+	set := Set{prompts: map[string][]*Prompt{}}
+	for _, name := range names {
+		if strings.HasPrefix(name, "_") {
+			continue
+		}
+
+		m := fileName.FindStringSubmatch(name)
+		if m == nil {
+			return nil, fmt.Errorf("%s: file name is not like name.v1.tmpl", name)
+		}
+
+		p, err := parse(name, m[1], m[2], partials, files)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		set.prompts[p.Name] = append(set.prompts[p.Name], p)
+	}
+
+	for _, versions := range set.prompts {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	}
+
+	return &set, nil
+}
+
+// parse parses a prompt file with the partials. The template is named
+// after the file, so a prompt can share its name with a partial.
+func parse(file string, name string, version string, partials []string, files map[string]string) (*Prompt, error) {
+	p := Prompt{Name: name}
+	if version != "" {
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, err
+		}
+		p.Version = v
+	}
+
+	body, err := p.parseHeader(files[file])
+	if err != nil {
+		return nil, err
+	}
+
+	p.tmpl = template.New(file).Funcs(funcs).Option("missingkey=error")
+	for _, partial := range partials {
+		if _, err := p.tmpl.New(partial).Parse(files[partial]); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.tmpl.Parse(body); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// parseHeader parses the header of a prompt file, if any, and returns the
+// text after it.
+func (p *Prompt) parseHeader(text string) (string, error) {
+	const delim = "---\n"
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if !strings.HasPrefix(text, delim) {
+		return text, nil
+	}
+	header, body, found := strings.Cut(text[len(delim):], "\n"+delim)
+	if !found {
+		return "", errors.New("header is not closed by ---")
+	}
+
+	for _, line := range strings.Split(header, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return "", fmt.Errorf("header line %q is not key: value", line)
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "description":
+			p.Description = value
+		case "required":
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					p.Required = append(p.Required, name)
+				}
+			}
+		default:
+			return "", fmt.Errorf("unknown header key %q", key)
+		}
+	}
+
+	return body, nil
+}
+
+// Get returns a prompt by name for its latest version, or by name and
+// version, like "qa.v1".
+func (s *Set) Get(id string) (*Prompt, error) {
+	name, version := id, -1
+	if base, v, found := strings.Cut(id, ".v"); found {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("prompt %q: invalid version", id)
+		}
+		name, version = base, n
+	}
+
+	versions := s.prompts[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("unknown prompt %q, expected one of %s", name, strings.Join(s.Names(), ", "))
+	}
+	if version < 0 {
+		return versions[len(versions)-1], nil
+	}
+
+	i := slices.IndexFunc(versions, func(p *Prompt) bool { return p.Version == version })
+	if i < 0 {
+		return nil, fmt.Errorf("unknown version %d of prompt %q", version, name)
+	}
+	return versions[i], nil
+}
+
+// Render renders a prompt of the set by name, like Prompt.Render.
+func (s *Set) Render(id string, vars map[string]any) (string, error) {
+	p, err := s.Get(id)
+	if err != nil {
+		return "", err
+	}
+	return p.Render(vars)
+}
+
+// Names returns the names of the prompts of the set, sorted.
+func (s *Set) Names() []string {
+	names := make([]string, 0, len(s.prompts))
+	for name := range s.prompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Versions returns the versions of a prompt, oldest first, like "qa.v1".
+func (s *Set) Versions(name string) []string {
+	var ids []string
+	for _, p := range s.prompts[name] {
+		ids = append(ids, p.ID())
+	}
+	return ids
+}
+
+// funcs are the functions prompts can use besides the ones of
+// text/template.
+var funcs = template.FuncMap{
+	"delimit": Delimit,
+	"quote":   Quote,
+}
+
+// Delimit wraps a text in <tag> and </tag> lines. Occurrences of the tags
+// in the text are escaped, so the text cannot end the block early and pass
+// for instructions.
+func Delimit(tag string, text string) string {
+	text = tagPattern(tag).ReplaceAllString(text, "&lt;${1}"+tag+"&gt;")
+	return "<" + tag + ">\n" + strings.TrimSpace(text) + "\n</" + tag + ">"
+}
+
+// tagPatterns caches the patterns of the tags Delimit escapes by tag
+// name, since prompts delimit the same few tags on every render.
+var tagPatterns sync.Map
+
+// tagPattern returns the pattern of the opening and closing tags of a
+// name, in any case and with any spaces inside the brackets.
+func tagPattern(tag string) *regexp.Regexp {
+	if re, exists := tagPatterns.Load(tag); exists {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(`(?i)<(/?)\s*` + regexp.QuoteMeta(tag) + `\s*>`)
+	tagPatterns.Store(tag, re)
+	return re
+}
+
+// Quote returns a text as a JSON string, in double quotes with the quotes
+// and control characters of the text escaped.
+func Quote(text string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(text)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Must panics on the error of loading a set of prompts, for sets that are
+// known to load.
+func Must(set *Set, err error) *Set {
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
@@ -0,0 +1,80 @@
+package rag
+
+import (
+	"context"
+	"io"
+
+	"github.com/dwhitena/go-genai-webinar/genai/chain"
+	"github.com/predictionguard/go-client"
+)
+
+// Retrieval is a query on its way through a retrieval chain: the query as
+// asked, which can refer to an image, the history of the conversation, if
+// any, and the chunks found for it.
+type Retrieval struct {
+	Query   string
+	History []client.ChatInputMessage
+	Results []Result
+}
+
+// Checked is a response and the factuality of its answer, checked against
+// the chunks it is based on.
+type Checked struct {
+	Response
+	Factuality float64
+}
+
+// Retrieve is the step that searches the index for the query.
+func (r RAG) Retrieve() chain.Step[Retrieval, Retrieval] {
+	return func(ctx context.Context, in Retrieval) (Retrieval, error) {
+		results, err := r.Search(ctx, in.Query)
+		if err != nil {
+			return Retrieval{}, err
+		}
+		in.Results = results
+		return in, nil
+	}
+}
+
+// Generate is the step that streams the answer to the question of the
+// query, based on the chunks found for it, to w.
+func (r RAG) Generate(w io.Writer) chain.Step[Retrieval, Response] {
+	return func(ctx context.Context, in Retrieval) (Response, error) {
+		question := questionOf(in.Query)
+
+		answer, err := r.Answer(ctx, question, in.Results, in.History, w)
+		if err != nil {
+			return Response{}, err
+		}
+
+		return Response{
+			Question:     question,
+			Answer:       answer.Content,
+			FinishReason: answer.FinishReason,
+			Usage:        answer.Usage,
+			Results:      in.Results,
+		}, nil
+	}
+}
+
+// Check is the step that checks the factuality of an answer.
+func (r RAG) Check() chain.Step[Response, Checked] {
+	return func(ctx context.Context, resp Response) (Checked, error) {
+		score, err := r.Factuality(ctx, resp.Results, resp.Answer)
+		if err != nil {
+			return Checked{}, err
+		}
+		return Checked{Response: resp, Factuality: score}, nil
+	}
+}
+
+// Chain is the retrieval chain of the pipeline, the retrieve and answer
+// steps one after the other, streaming the answer to w. Unlike Ask, it
+// neither caches answers nor escalates refusals; those are for a chain
+// to add where it needs them.
+func (r RAG) Chain(w io.Writer) chain.Step[Retrieval, Response] {
+	return chain.Named("rag", chain.Then(
+		chain.Named("retrieve", r.Retrieve()),
+		chain.Named("answer", r.Generate(w)),
+	))
+}
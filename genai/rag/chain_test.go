@@ -0,0 +1,108 @@
+package rag_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dwhitena/go-genai-webinar/genai/chain"
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/llm/llmtest"
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
+	"github.com/predictionguard/go-client"
+)
+
+func TestChain(t *testing.T) {
+	fake := &llmtest.Client{Answers: []string{"Goroutines are cheap threads."}}
+
+	texts := []string{
+		"goroutines are lightweight threads managed by the go runtime",
+		"channels connect concurrent goroutines",
+		"a map is a hash table",
+	}
+	var chunks rag.VectorizedChunks
+	for i, text := range texts {
+		chunks = append(chunks, rag.VectorizedChunk{Id: i, Chunk: text, Vector: fake.Vector(text)})
+	}
+
+	r := rag.RAG{
+		Client: fake,
+		Chunks: chunks,
+		Model:  llm.DefaultRegistry[llm.DefaultModel],
+		TopK:   2,
+	}
+
+	var steps []string
+	ctx := chain.WithHooks(context.Background(), chain.Hooks{
+		After: func(ctx context.Context, e chain.Event) {
+			steps = append(steps, e.Step)
+		},
+	})
+
+	history := []client.ChatInputMessage{
+		{Role: client.Roles.User, Content: "What is Go?"},
+		{Role: client.Roles.Assistant, Content: "A programming language."},
+	}
+
+	var b strings.Builder
+	resp, err := r.Chain(&b)(ctx, rag.Retrieval{Query: "What are goroutines?", History: history})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The answer is streamed to the writer and returned in full.
+	if want := "Goroutines are cheap threads."; resp.Answer != want || b.String() != want {
+		t.Errorf("got answer %q, streamed %q, want %q", resp.Answer, b.String(), want)
+	}
+	if resp.Question != "What are goroutines?" || resp.FinishReason != "stop" {
+		t.Errorf("got question %q and finish reason %q", resp.Question, resp.FinishReason)
+	}
+
+	// The chunks that share the most words with the query come first.
+	if len(resp.Results) != 2 || resp.Results[0].Chunk.Id != 0 || resp.Results[1].Chunk.Id != 1 {
+		t.Errorf("got results %+v, want chunks 0 and 1", resp.Results)
+	}
+
+	if want := []string{"rag/retrieve", "rag/answer", "rag"}; strings.Join(steps, " ") != strings.Join(want, " ") {
+		t.Errorf("got steps %v, want %v", steps, want)
+	}
+
+	// The query is embedded, and the model asked once: the system prompt,
+	// the history and the question with the context of the results.
+	if embeds := fake.CallsOf(llm.OpEmbedding); len(embeds) != 1 || embeds[0].Text != "What are goroutines?" {
+		t.Errorf("got embedding calls %+v, want one of the query", embeds)
+	}
+	chats := fake.CallsOf(llm.OpChat)
+	if len(chats) != 1 {
+		t.Fatalf("got %d chat calls, want 1", len(chats))
+	}
+	messages := chats[0].Messages
+	if len(messages) != 4 {
+		t.Fatalf("got %d messages, want 4", len(messages))
+	}
+	if messages[0].Role != client.Roles.System || messages[1] != history[0] || messages[2] != history[1] {
+		t.Errorf("got messages %+v, want the system prompt then the history", messages[:3])
+	}
+	last := messages[3]
+	if last.Role != client.Roles.User || !strings.Contains(last.Content, "What are goroutines?") || !strings.Contains(last.Content, texts[0]) || !strings.Contains(last.Content, texts[1]) || strings.Contains(last.Content, texts[2]) {
+		t.Errorf("got question %q, want it with the context of chunks 0 and 1 only", last.Content)
+	}
+}
+
+func TestChainError(t *testing.T) {
+	fake := &llmtest.Client{Err: errors.New("boom")}
+
+	r := rag.RAG{
+		Client: fake,
+		Model:  llm.DefaultRegistry[llm.DefaultModel],
+	}
+
+	_, err := r.Chain(&strings.Builder{})(context.Background(), rag.Retrieval{Query: "What are goroutines?"})
+	if err == nil || !strings.HasPrefix(err.Error(), "rag: retrieve: ") {
+		t.Errorf("got error %v, want it from the retrieve step", err)
+	}
+	if chats := fake.CallsOf(llm.OpChat); len(chats) != 0 {
+		t.Errorf("got %d chat calls after the retrieval failed, want 0", len(chats))
+	}
+}
@@ -0,0 +1,149 @@
+// Package rag provides the retrieval augmented generation pipeline of the
+// workshop as a library: ingesting sources into an index of vectorized
+// chunks, searching the index and answering questions from the results.
+package rag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Set of chunk types.
+const (
+	TypeText  = "text"
+	TypeImage = "image"
+)
+
+// VectorizedChunk is a struct that holds a vectorized chunk.
+type VectorizedChunk struct {
+	Id            int       `json:"id"`
+	Chunk         string    `json:"chunk"`
+	Vector        []float64 `json:"vector"`
+	Metadata      string    `json:"metadata"`
+	Source        string    `json:"source"`
+	SourceVersion string    `json:"source_version"`
+	Hash          string    `json:"hash"`
+	Sources       []string  `json:"sources"`
+	Type          string    `json:"type"`
+	ImageURL      string    `json:"image_url"`
+}
+
+// VectorizedChunks is a slice of vectorized chunks.
+type VectorizedChunks []VectorizedChunk
+
+// HashChunk returns the content hash of a chunk of text.
+func HashChunk(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadChunks loads the vectorized chunks of an index. A missing file means
+// nothing was indexed yet.
+func LoadChunks(path string) (VectorizedChunks, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return VectorizedChunks{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var chunks VectorizedChunks
+	if err := json.NewDecoder(f).Decode(&chunks); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// Dimension returns the dimension of the vectors of the chunks, the one
+// of the model that embedded them, or 0 if none is embedded.
+func (chunks VectorizedChunks) Dimension() int {
+	for _, c := range chunks {
+		if len(c.Vector) > 0 {
+			return len(c.Vector)
+		}
+	}
+	return 0
+}
+
+// Save writes the vectorized chunks of an index to a JSON file.
+func (chunks VectorizedChunks) Save(path string) error {
+	outJSON, err := json.MarshalIndent(chunks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, outJSON, 0644)
+}
+
+// =============================================================================
+
+// ChunkRef references a chunk of an index by its source and content hash.
+type ChunkRef struct {
+	Source string `json:"source"`
+	Hash   string `json:"hash"`
+}
+
+// SourceState is what an ingestion recorded of a source: the version it
+// was indexed at and the chunks of the index that hold its content. Those
+// include the chunks of other sources its own chunks were merged into as
+// duplicates, so a source whose chunks were all merged away still has a
+// version.
+type SourceState struct {
+	Version string     `json:"version"`
+	Chunks  []ChunkRef `json:"chunks"`
+}
+
+// Index is the chunks of an ingestion and the state of their sources by
+// name. The states are kept next to the chunks, in a file named after the
+// one of the chunks, so the chunks stay a plain list of vectors.
+type Index struct {
+	Chunks  VectorizedChunks
+	Sources map[string]SourceState
+}
+
+// SourcesPath returns the path of the file of the source states of the
+// index at path, chunks.sources.json for chunks.json.
+func SourcesPath(path string) string {
+	return strings.TrimSuffix(path, ".json") + ".sources.json"
+}
+
+// LoadIndex loads the chunks of an index and the states of their sources.
+// An index saved before the states were kept has none.
+func LoadIndex(path string) (Index, error) {
+	chunks, err := LoadChunks(path)
+	if err != nil {
+		return Index{}, err
+	}
+
+	idx := Index{Chunks: chunks, Sources: map[string]SourceState{}}
+	data, err := os.ReadFile(SourcesPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return Index{}, err
+	}
+	if err := json.Unmarshal(data, &idx.Sources); err != nil {
+		return Index{}, err
+	}
+	return idx, nil
+}
+
+// Save writes the chunks of an index and the states of their sources to
+// JSON files.
+func (idx Index) Save(path string) error {
+	if err := idx.Chunks.Save(path); err != nil {
+		return err
+	}
+
+	outJSON, err := json.MarshalIndent(idx.Sources, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(SourcesPath(path), outJSON, 0644)
+}
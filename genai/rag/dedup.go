@@ -0,0 +1,106 @@
+package rag
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"slices"
+	"strings"
+)
+
+// shingleSize is the number of words in each shingle of a SimHash.
+const shingleSize = 3
+
+// SimHash computes a 64 bit SimHash fingerprint of a text from its word
+// shingles. Texts that are nearly the same have fingerprints that differ
+// in only a few bits.
+func SimHash(text string) uint64 {
+	words := strings.Fields(strings.ToLower(text))
+
+	// Split the words into overlapping shingles.
+	shingles := []string{strings.Join(words, " ")}
+	if len(words) > shingleSize {
+		shingles = shingles[:0]
+		for i := 0; i+shingleSize <= len(words); i++ {
+			shingles = append(shingles, strings.Join(words[i:i+shingleSize], " "))
+		}
+	}
+
+	// Every shingle votes on every bit of the fingerprint.
+	var weights [64]int
+	for _, shingle := range shingles {
+		h := fnv.New64a()
+		h.Write([]byte(shingle))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint
+}
+
+// Similarity returns the fraction of bits two fingerprints share.
+func Similarity(a uint64, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/64
+}
+
+// Dedup drops the chunks that are near-duplicates of an earlier chunk,
+// given a similarity threshold between 0 and 1. The chunk that is kept
+// references the sources of every chunk it stands in for. It returns the
+// unique chunks and the number of duplicates that were dropped.
+func Dedup(chunks VectorizedChunks, threshold float64) (VectorizedChunks, int) {
+	unique, kept := dedup(chunks, threshold)
+	for i, c := range chunks {
+		if !slices.Contains(unique[kept[i]].Sources, c.Source) {
+			unique[kept[i]].Sources = append(unique[kept[i]].Sources, c.Source)
+		}
+	}
+	return unique, len(chunks) - len(unique)
+}
+
+// dedup drops the chunks that are near-duplicates of an earlier chunk. It
+// returns the unique chunks, each referencing its own source, and for
+// every chunk the index of the unique chunk that stands for it.
+func dedup(chunks VectorizedChunks, threshold float64) (VectorizedChunks, []int) {
+	unique := VectorizedChunks{}
+	fingerprints := []uint64{}
+	kept := make([]int, len(chunks))
+
+	for j, c := range chunks {
+		fingerprint := SimHash(c.Chunk)
+
+		// Merge the chunk into the first chunk it is similar to. Images
+		// are only the same when they have the same URL.
+		merged := false
+		for i, seen := range fingerprints {
+			if (c.Type == TypeImage || unique[i].Type == TypeImage) && c.ImageURL != unique[i].ImageURL {
+				continue
+			}
+			if Similarity(fingerprint, seen) < threshold {
+				continue
+			}
+			kept[j] = i
+			merged = true
+			break
+		}
+		if merged {
+			continue
+		}
+
+		c.Sources = []string{c.Source}
+		kept[j] = len(unique)
+		unique = append(unique, c)
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	return unique, kept
+}
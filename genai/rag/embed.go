@@ -0,0 +1,118 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/predictionguard/go-client"
+)
+
+// imageExtensions are the file extensions of images in a query.
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp", ".bmp"}
+
+// isImage reports if a word of a query refers to an image, either as a
+// URL, a local file or a base64 data URL.
+func isImage(word string) bool {
+	if strings.HasPrefix(word, "data:image/") {
+		return true
+	}
+	if strings.HasPrefix(word, "http://") || strings.HasPrefix(word, "https://") {
+		u, err := url.Parse(word)
+		if err != nil {
+			return false
+		}
+		return slices.Contains(imageExtensions, strings.ToLower(path.Ext(u.Path)))
+	}
+	if _, err := os.Stat(word); err != nil {
+		return false
+	}
+	return slices.Contains(imageExtensions, strings.ToLower(filepath.Ext(word)))
+}
+
+// ParseQuery splits a query into the image it refers to (if any) and the
+// text around it, so a screenshot can be searched on with or without a
+// question.
+func ParseQuery(query string) (string, string) {
+	image := ""
+	words := []string{}
+	for _, word := range strings.Fields(query) {
+		if image == "" && isImage(word) {
+			image = word
+			continue
+		}
+		words = append(words, word)
+	}
+	return image, strings.Join(words, " ")
+}
+
+// NewImage loads an image from a URL, a local file or a base64 data URL.
+// Local files are sent base64 encoded.
+func NewImage(imageLink string) (client.Base64Encoder, error) {
+	switch {
+	case strings.HasPrefix(imageLink, "http://") || strings.HasPrefix(imageLink, "https://"):
+		return client.NewImageNetwork(imageLink)
+	case strings.HasPrefix(imageLink, "data:"):
+		_, data, found := strings.Cut(imageLink, ";base64,")
+		if !found {
+			return nil, errors.New("data URL is not base64 encoded")
+		}
+		return client.NewImageBase64(data), nil
+	default:
+		return client.NewImageFile(imageLink)
+	}
+}
+
+// Embedder turns text, optionally together with an image, into vectors.
+// The Model defaults to the embedding model of the API.
+type Embedder struct {
+	Client llm.Client
+	Model  llm.ModelSpec
+}
+
+// Embed returns the vector for a text and an optional image, given as a
+// URL, a local file or a base64 data URL.
+func (e Embedder) Embed(ctx context.Context, imageLink string, text string) ([]float64, error) {
+	spec := e.Model
+	if spec.Name() == "" {
+		spec = llm.DefaultRegistry[llm.EmbeddingModel]
+	}
+
+	input := []client.EmbeddingInput{
+		{
+			Text: text,
+		},
+	}
+	if imageLink != "" {
+		if err := spec.Supports(llm.OpVision); err != nil {
+			return nil, err
+		}
+		image, err := NewImage(imageLink)
+		if err != nil {
+			return nil, fmt.Errorf("image: %w", err)
+		}
+		input[0].Image = image
+	}
+
+	resp, err := e.Client.Embedding(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("embedding: %w", err)
+	}
+	if len(resp.Data) == 0 {
+		return nil, errors.New("embedding: no data in response")
+	}
+
+	vector := resp.Data[0].Embedding
+	if spec.EmbeddingDimension > 0 && len(vector) != spec.EmbeddingDimension {
+		return nil, fmt.Errorf("embedding: got %d dimensions from %s, expected %d", len(vector), spec.Name(), spec.EmbeddingDimension)
+	}
+
+	return vector, nil
+}
@@ -0,0 +1,191 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/predictionguard/go-client"
+)
+
+// Example is a worked example of an input and the output expected for it,
+// shown to a model before a question. An example with a Context is a
+// question about it, asked through the same prompt as the question that
+// follows, so the model sees answers drawn from a context and refusals of
+// what a context does not say. The Vector is the embedding of the Input,
+// whose Hash tells if it is still current.
+type Example struct {
+	Input   string    `json:"input"`
+	Context string    `json:"context,omitempty"`
+	Output  string    `json:"output"`
+	Vector  []float64 `json:"vector,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+}
+
+// Examples is a store of worked examples.
+type Examples []Example
+
+// LoadExamples loads the examples of a JSON file, a list of objects with
+// an input, an optional context and an output.
+func LoadExamples(path string) (Examples, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var examples Examples
+	if err := json.Unmarshal(data, &examples); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return examples, nil
+}
+
+// Save writes the examples, with their vectors, to a JSON file.
+func (examples Examples) Save(path string) error {
+	outJSON, err := json.MarshalIndent(examples, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, outJSON, 0644)
+}
+
+// Embed embeds the inputs of the examples that have no vector, or whose
+// input changed since they were embedded, and returns how many it
+// embedded.
+func (examples Examples) Embed(ctx context.Context, embedder Embedder) (int, error) {
+	embedded := 0
+	for i := range examples {
+		hash := HashChunk(examples[i].Input)
+		if examples[i].Vector != nil && examples[i].Hash == hash {
+			continue
+		}
+
+		vector, err := embedder.Embed(ctx, "", examples[i].Input)
+		if err != nil {
+			return embedded, fmt.Errorf("example %d: %w", i+1, err)
+		}
+		examples[i].Vector = vector
+		examples[i].Hash = hash
+		embedded++
+	}
+
+	return embedded, nil
+}
+
+// Select returns up to k of the embedded examples most similar to a
+// vector, most similar first. Examples whose messages do not fit in what
+// is left of the budget of tokens are skipped, unless the budget is 0.
+// The tokens of the prompt an example is asked through are not counted.
+func (examples Examples) Select(vector []float64, k int, budget int) ([]Example, error) {
+	type scored struct {
+		example    Example
+		similarity float64
+	}
+
+	var candidates []scored
+	for _, example := range examples {
+		if example.Vector == nil {
+			continue
+		}
+		similarity, err := CosineSimilarity(example.Vector, vector)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, scored{example, similarity})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].similarity > candidates[j].similarity
+	})
+
+	var selected []Example
+	tokens := 0
+	for _, c := range candidates {
+		if k > 0 && len(selected) == k {
+			break
+		}
+		input := c.example.Input
+		if c.example.Context != "" {
+			input = c.example.Context + "\n\n" + input
+		}
+		cost := llm.EstimateMessagesTokens([]client.ChatInputMessage{
+			{Role: client.Roles.User, Content: input},
+			{Role: client.Roles.Assistant, Content: c.example.Output},
+		})
+		if budget > 0 && tokens+cost > budget {
+			continue
+		}
+		selected = append(selected, c.example)
+		tokens += cost
+	}
+
+	return selected, nil
+}
+
+// ExampleMessages renders examples as pairs of user and assistant
+// messages, in reverse, so the most similar example is the nearest to the
+// question that follows. The examples with a context are asked through
+// the qa prompt of prompts, the default ones if nil, and QAPrompt if qa
+// is empty; the others as they are.
+func ExampleMessages(examples []Example, prompts *prompt.Set, qa string) ([]client.ChatInputMessage, error) {
+	if prompts == nil {
+		prompts = prompt.Default()
+	}
+
+	messages := make([]client.ChatInputMessage, 0, 2*len(examples))
+	for _, example := range slices.Backward(examples) {
+		input := example.Input
+		if example.Context != "" {
+			var err error
+			input, err = prompts.Render(cmp.Or(qa, QAPrompt), map[string]any{
+				"context":  example.Context,
+				"question": example.Input,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("example %q: %w", example.Input, err)
+			}
+		}
+
+		messages = append(messages,
+			client.ChatInputMessage{Role: client.Roles.User, Content: input},
+			client.ChatInputMessage{Role: client.Roles.Assistant, Content: example.Output},
+		)
+	}
+	return messages, nil
+}
+
+// FewShot selects the K examples most similar to a question, within a
+// Budget of tokens, to show the model before it.
+type FewShot struct {
+	Embedder Embedder
+	Examples Examples
+	K        int
+	Budget   int
+}
+
+// Messages embeds a question and returns the messages of the examples
+// selected for it, none if there are no examples. The examples with a
+// context are asked through the qa prompt of prompts, like the question.
+func (f FewShot) Messages(ctx context.Context, question string, prompts *prompt.Set, qa string) ([]client.ChatInputMessage, error) {
+	if len(f.Examples) == 0 || f.K <= 0 {
+		return nil, nil
+	}
+
+	vector, err := f.Embedder.Embed(ctx, "", question)
+	if err != nil {
+		return nil, fmt.Errorf("few-shot: %w", err)
+	}
+
+	selected, err := f.Examples.Select(vector, f.K, f.Budget)
+	if err != nil {
+		return nil, fmt.Errorf("few-shot: %w", err)
+	}
+
+	return ExampleMessages(selected, prompts, qa)
+}
@@ -0,0 +1,41 @@
+package rag_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
+	"github.com/predictionguard/go-client"
+)
+
+func TestExampleMessages(t *testing.T) {
+	examples := []rag.Example{
+		{Input: "What is a goroutine?", Context: "Goroutines are lightweight threads.", Output: "A lightweight thread."},
+		{Input: "Hello", Output: "Hi!"},
+	}
+
+	messages, err := rag.ExampleMessages(examples, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("got %d messages, want 4", len(messages))
+	}
+
+	// The most similar example comes last, next to the question.
+	if messages[0].Content != "Hello" || messages[1].Content != "Hi!" {
+		t.Errorf("got %q and %q, want the example without a context as it is", messages[0].Content, messages[1].Content)
+	}
+
+	asked := messages[2]
+	if asked.Role != client.Roles.User || !strings.Contains(asked.Content, "<context>\nGoroutines are lightweight threads.\n</context>") || !strings.Contains(asked.Content, "What is a goroutine?") {
+		t.Errorf("got %q, want the example asked about its context through the qa prompt", asked.Content)
+	}
+	if messages[3].Role != client.Roles.Assistant || messages[3].Content != "A lightweight thread." {
+		t.Errorf("got answer %+v", messages[3])
+	}
+
+	if _, err := rag.ExampleMessages(examples, nil, "missing"); err == nil {
+		t.Error("got messages, want an error for a missing prompt")
+	}
+}
@@ -0,0 +1,237 @@
+package rag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// importPath works out the import path of the Go package in a directory
+// from the module path in the closest go.mod file.
+func importPath(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for d := abs; ; d = filepath.Dir(d) {
+		data, err := os.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) == 2 && fields[0] == "module" {
+					rel, err := filepath.Rel(d, abs)
+					if err != nil {
+						return "", err
+					}
+					return path.Join(strings.Trim(fields[1], `"`), filepath.ToSlash(rel)), nil
+				}
+			}
+		}
+
+		// Without a module the directory is the best we can do.
+		if filepath.Dir(d) == d {
+			return filepath.ToSlash(abs), nil
+		}
+	}
+}
+
+// GoSources turns Go package patterns into sources, where a pattern ending
+// in "/..." includes every package below it.
+func GoSources(patterns []string) ([]Source, error) {
+	var dirs []string
+	for _, pattern := range patterns {
+		root, recursive := strings.CutSuffix(pattern, "/...")
+		if !recursive {
+			dirs = append(dirs, root)
+			continue
+		}
+
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			name := d.Name()
+			if p != root && (name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			if matches, _ := filepath.Glob(filepath.Join(p, "*.go")); len(matches) > 0 {
+				dirs = append(dirs, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sources := []Source{}
+	for _, dir := range dirs {
+		path, err := importPath(dir)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, Source{GoDir: dir, ImportPath: path})
+	}
+	return sources, nil
+}
+
+// GoChunks parses the Go package in a directory and splits it into one
+// chunk per func, type and method declaration, including its doc comment.
+// Only the files that match the build constraints of the default build
+// context are indexed, so a package is indexed as it builds here.
+// Each chunk starts with, and has as metadata, the import path, file and
+// line range of the declaration. The version of the package source that
+// was indexed previously (if any) is used to skip packages that have not
+// changed, in which case ErrNotModified is returned.
+func GoChunks(dir string, importPath string, version string) (VectorizedChunks, string, error) {
+
+	// Read the non-test Go files of the package in a stable order.
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(filenames)
+
+	h := sha256.New()
+	sources := map[string][]byte{}
+	for _, filename := range filenames {
+		if strings.HasSuffix(filename, "_test.go") {
+			continue
+		}
+		match, err := build.Default.MatchFile(dir, filepath.Base(filename))
+		if err != nil {
+			return nil, "", err
+		}
+		if !match {
+			continue
+		}
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, "", err
+		}
+		sources[filename] = content
+		fmt.Fprintf(h, "%s\n%d\n", filepath.Base(filename), len(content))
+		h.Write(content)
+	}
+
+	// Compare the package version with the previous one.
+	newVersion := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	if newVersion == version {
+		return nil, version, ErrNotModified
+	}
+
+	// Parse the files, grouped by package name, and note the declaration
+	// of every type, which go/doc does not keep for grouped types.
+	fset := token.NewFileSet()
+	packages := map[string][]*ast.File{}
+	typeDecls := map[*ast.TypeSpec]*ast.GenDecl{}
+	for _, filename := range filenames {
+		content, exists := sources[filename]
+		if !exists {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filename, content, parser.ParseComments)
+		if err != nil {
+			return nil, "", err
+		}
+		packages[file.Name.Name] = append(packages[file.Name.Name], file)
+
+		for _, decl := range file.Decls {
+			if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.TYPE {
+				for _, spec := range d.Specs {
+					typeDecls[spec.(*ast.TypeSpec)] = d
+				}
+			}
+		}
+	}
+
+	// chunk turns the source of a declaration, after its doc comment and
+	// the keyword it lacks in a group, into a chunk.
+	chunks := VectorizedChunks{}
+	chunk := func(name string, comment *ast.CommentGroup, keyword string, start token.Pos, end token.Pos) {
+		from := fset.Position(start)
+		to := fset.Position(end)
+		text := keyword + string(sources[from.Filename][from.Offset:to.Offset])
+		if comment != nil {
+			from = fset.Position(comment.Pos())
+			text = string(sources[from.Filename][from.Offset:fset.Position(comment.End()).Offset]) + "\n" + text
+		}
+		location := fmt.Sprintf("%s.%s %s:%d-%d", importPath, name, from.Filename, from.Line, to.Line)
+		chunks = append(chunks, VectorizedChunk{
+			Chunk:    fmt.Sprintf("// %s\n%s", location, text),
+			Metadata: location,
+			Type:     TypeText,
+		})
+	}
+
+	// funcChunk adds a chunk for a func or method.
+	funcChunk := func(f *doc.Func) {
+		name := f.Name
+		if f.Recv != "" {
+			name = fmt.Sprintf("(%s).%s", f.Recv, f.Name)
+		}
+		chunk(name, f.Decl.Doc, "", f.Decl.Pos(), f.Decl.End())
+	}
+
+	// typeChunk adds a chunk for a type. A type in a group spans its own
+	// spec only, documented by its own comment, or by the comment of the
+	// group when it is the only type in it.
+	typeChunk := func(t *doc.Type) {
+		spec := t.Decl.Specs[0].(*ast.TypeSpec)
+		decl := typeDecls[spec]
+		if !decl.Lparen.IsValid() {
+			chunk(t.Name, decl.Doc, "", decl.Pos(), decl.End())
+			return
+		}
+
+		comment := spec.Doc
+		if comment == nil && len(decl.Specs) == 1 {
+			comment = decl.Doc
+		}
+		chunk(t.Name, comment, "type ", spec.Pos(), spec.End())
+	}
+
+	names := []string{}
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p, err := doc.NewFromFiles(fset, packages[name], importPath, doc.AllDecls|doc.PreserveAST)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, f := range p.Funcs {
+			funcChunk(f)
+		}
+		for _, t := range p.Types {
+			typeChunk(t)
+
+			for _, f := range t.Funcs {
+				funcChunk(f)
+			}
+			for _, f := range t.Methods {
+				funcChunk(f)
+			}
+		}
+	}
+
+	return chunks, newVersion, nil
+}
@@ -0,0 +1,87 @@
+package rag_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
+)
+
+const shapes = `// Package shapes draws shapes.
+package shapes
+
+// Point is a point on a plane.
+type Point struct{ X, Y int }
+
+// Shapes of a drawing.
+type (
+	// Circle is a circle around a point.
+	Circle struct {
+		Center Point
+		Radius int
+	}
+
+	Square struct{ Corner Point }
+)
+
+// Sizes of a drawing.
+type (
+	Size int
+)
+
+// Area returns the area of the circle.
+func (c Circle) Area() float64 {
+	return 3.14 * float64(c.Radius*c.Radius)
+}
+`
+
+const ignored = `//go:build ignore
+
+package shapes
+
+// Triangle is only drawn with a build tag.
+type Triangle struct{}
+`
+
+func TestGoChunks(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"shapes.go": shapes, "triangle.go": ignored} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chunks, _, err := rag.GoChunks(dir, "example.com/shapes", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, c := range chunks {
+		if strings.HasPrefix(c.Metadata, "example.com/shapes.Square ") && !strings.HasSuffix(c.Metadata, ":15-15") {
+			t.Errorf("got location %s of Square, want line 15 only", c.Metadata)
+		}
+		name, _, _ := strings.Cut(c.Metadata, " ")
+		_, text, _ := strings.Cut(c.Chunk, "\n")
+		got[strings.TrimPrefix(name, "example.com/shapes.")] = text
+	}
+
+	want := map[string]string{
+		"Point":  "// Point is a point on a plane.\ntype Point struct{ X, Y int }",
+		"Circle": "// Circle is a circle around a point.\ntype Circle struct {\n\t\tCenter Point\n\t\tRadius int\n\t}",
+		"Square": "type Square struct{ Corner Point }",
+		"Size":   "// Sizes of a drawing.\ntype Size int",
+		"(Circle).Area": "// Area returns the area of the circle.\nfunc (c Circle) Area() float64 {\n" +
+			"\treturn 3.14 * float64(c.Radius*c.Radius)\n}",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got chunks of %d declarations, want %d", len(got), len(want))
+	}
+	for name, text := range want {
+		if got[name] != text {
+			t.Errorf("got chunk of %s:\n%s\nwant:\n%s", name, got[name], text)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+)
+
+// Source is a website to index with an optional start and end string, or
+// the directory of a Go package to index.
+type Source struct {
+	Website    string
+	Start      string
+	End        string
+	GoDir      string
+	ImportPath string
+}
+
+// Name identifies the source in the index.
+func (s Source) Name() string {
+	if s.GoDir != "" {
+		return s.ImportPath
+	}
+	return s.Website
+}
+
+// DefaultSources are indexed when no sources are given.
+var DefaultSources = []Source{
+	{Website: "https://go.dev/doc/contribute", Start: "# Contribution Guide"},
+}
+
+// ParseSources turns website URLs and Go package patterns into sources.
+func ParseSources(values []string) ([]Source, error) {
+	sources := []Source{}
+	for _, value := range values {
+		if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+			sources = append(sources, Source{Website: value})
+			continue
+		}
+
+		goSources, err := GoSources([]string{value})
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, goSources...)
+	}
+	return sources, nil
+}
+
+// Summary counts what happened to the chunks during an ingestion. The
+// PendingTokens estimate the text of the chunks left to embed.
+type Summary struct {
+	Added         int
+	Updated       int
+	Removed       int
+	Unchanged     int
+	Duplicates    int
+	Pending       int
+	PendingTokens int
+}
+
+// String implements the fmt.Stringer interface.
+func (s Summary) String() string {
+	str := fmt.Sprintf("%d added, %d updated, %d removed, %d unchanged, %d duplicates dropped",
+		s.Added, s.Updated, s.Removed, s.Unchanged, s.Duplicates)
+	if s.Pending > 0 {
+		str += fmt.Sprintf(", %d pending", s.Pending)
+	}
+	return str
+}
+
+// PartialError is returned by Ingest when embedding failed part way. The
+// index returned with it holds the chunks embedded so far and is worth
+// saving, so the next ingestion only embeds the Pending chunks.
+type PartialError struct {
+	Pending int
+	Err     error
+}
+
+// Error implements the error interface.
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d chunk(s) pending: %s", e.Pending, e.Err)
+}
+
+// Unwrap returns the error of the embedding that failed.
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// Ingester downloads, chunks and embeds sources into an index. With
+// DryRun set, nothing is embedded and the summary only counts what would
+// be, to forecast the cost of an ingestion.
+type Ingester struct {
+	Embedder   Embedder
+	Similarity float64
+	DryRun     bool
+	Progress   io.Writer
+}
+
+// logf writes progress information when a writer is provided.
+func (ing Ingester) logf(format string, v ...any) {
+	if ing.Progress != nil {
+		fmt.Fprintf(ing.Progress, format, v...)
+	}
+}
+
+// Ingest updates the index of a previous ingestion with the current
+// content of the sources. Only new or changed chunks are embedded, near
+// duplicates are dropped and the chunks of sources that are gone are
+// removed. The state of every source is recorded in the index, so a source
+// whose chunks were all merged into the chunks of other sources is only
+// fetched again once it changes, and the sources every chunk stands for
+// are rebuilt on each ingestion.
+//
+// A dry run returns an empty index, only the summary.
+//
+// When embedding fails, Ingest stops and returns a *PartialError together
+// with the chunks embedded so far. Their sources are marked as changed, so
+// the pending chunks are embedded by the next ingestion. On any other
+// error the index is empty and must not be saved.
+func (ing Ingester) Ingest(ctx context.Context, previous Index, sources []Source) (Index, Summary, error) {
+	if len(sources) == 0 {
+		return Index{}, Summary{}, errors.New("no sources to ingest")
+	}
+
+	// Index the previous chunks by source and content hash, so unchanged
+	// chunks keep their vectors. Chunks indexed before sources and hashes
+	// were tracked all came from the first source, and sources indexed
+	// before their states were recorded are at the version of their
+	// chunks.
+	known := map[string]VectorizedChunk{}
+	versions := map[string]string{}
+	prevChunks := append(VectorizedChunks{}, previous.Chunks...)
+	for i, c := range prevChunks {
+		if c.Source == "" {
+			c.Source = sources[0].Name()
+		}
+		if c.Hash == "" {
+			c.Hash = HashChunk(c.Chunk)
+		}
+		prevChunks[i] = c
+		known[c.Source+" "+c.Hash] = c
+		versions[c.Source] = c.SourceVersion
+	}
+	for name, state := range previous.Sources {
+		versions[name] = state.Version
+	}
+
+	// Collect the chunks of every source, reusing the previous chunks of
+	// the sources that have not changed.
+	candidates := VectorizedChunks{}
+	unchanged := map[string]bool{}
+	current := map[string]string{}
+	for _, src := range sources {
+		name := src.Name()
+
+		var chunks VectorizedChunks
+		var version string
+		var err error
+		if src.GoDir != "" {
+			chunks, version, err = GoChunks(src.GoDir, src.ImportPath, versions[name])
+		} else {
+			chunks, version, err = WebsiteChunks(ctx, src.Website, src.Start, src.End, versions[name])
+		}
+		if errors.Is(err, ErrNotModified) {
+			ing.logf("%s has not changed\n", name)
+			unchanged[name] = true
+			current[name] = versions[name]
+			for _, c := range prevChunks {
+				if c.Source == name {
+					candidates = append(candidates, c)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return Index{}, Summary{}, fmt.Errorf("%s: %w", name, err)
+		}
+
+		current[name] = version
+		for _, c := range chunks {
+			c.Source = name
+			c.SourceVersion = version
+			c.Hash = HashChunk(c.Chunk)
+			candidates = append(candidates, c)
+		}
+	}
+
+	// Drop the boilerplate that repeats within and across sources.
+	var s Summary
+	vectorizedChunks, kept := dedup(candidates, ing.Similarity)
+	s.Duplicates = len(candidates) - len(vectorizedChunks)
+
+	// Note the chunks that hold the content of every source. The chunks
+	// of an unchanged source are not chunked again, so the chunks of other
+	// sources they were merged into are carried over from its state.
+	refs := map[string][]ChunkRef{}
+	for j, c := range candidates {
+		k := vectorizedChunks[kept[j]]
+		refs[c.Source] = addRef(refs[c.Source], ChunkRef{Source: k.Source, Hash: k.Hash})
+	}
+	for name := range unchanged {
+		for _, ref := range previous.Sources[name].Chunks {
+			if ref.Source != name {
+				refs[name] = addRef(refs[name], ref)
+			}
+		}
+	}
+
+	// Embed only the chunks that are new or changed, with the dimension
+	// of the chunks that are not.
+	dimension := previous.Chunks.Dimension()
+	matched := map[string]bool{}
+	fresh := map[string]int{}
+	var embedErr *PartialError
+	for i, c := range vectorizedChunks {
+		key := c.Source + " " + c.Hash
+
+		if old, exists := known[key]; exists {
+			matched[key] = true
+			vectorizedChunks[i].Vector = old.Vector
+			s.Unchanged++
+			continue
+		}
+
+		// Images are embedded together with their alt text and caption.
+		text := c.Chunk
+		if c.Type == TypeImage {
+			text = c.Metadata
+		}
+		if ing.DryRun {
+			s.Pending++
+			s.PendingTokens += llm.EstimateTokens(text)
+			continue
+		}
+
+		ing.logf("Embedding chunk %d of %d from %s\n", i+1, len(vectorizedChunks), c.Source)
+		vector, err := ing.Embedder.Embed(ctx, c.ImageURL, text)
+		if err != nil {
+			embedErr = &PartialError{Err: fmt.Errorf("%s: %w", c.Source, err)}
+			break
+		}
+		if dimension > 0 && len(vector) != dimension {
+			return Index{}, Summary{}, fmt.Errorf("%s: got %d dimensions, the index has %d: it was embedded with another model", c.Source, len(vector), dimension)
+		}
+		vectorizedChunks[i].Vector = vector
+		fresh[c.Source]++
+	}
+	if ing.DryRun {
+		return Index{}, s, nil
+	}
+
+	// Keep what was embedded before a failure, and have the next
+	// ingestion revisit the sources with pending chunks.
+	if embedErr != nil {
+		embedded := VectorizedChunks{}
+		for _, c := range vectorizedChunks {
+			if c.Vector == nil {
+				current[c.Source] = ""
+				s.Pending++
+				embedErr.Pending++
+				continue
+			}
+			embedded = append(embedded, c)
+		}
+		vectorizedChunks = embedded
+	}
+
+	// Previous chunks that were not matched are gone, either because
+	// they changed or because their source no longer exists.
+	stale := map[string]int{}
+	for key, c := range known {
+		if !matched[key] {
+			stale[c.Source]++
+		}
+	}
+
+	// Per source, a new chunk that takes the place of a chunk that is
+	// gone counts as an update, anything left over was added or removed.
+	for src := range fresh {
+		updated := min(fresh[src], stale[src])
+		s.Updated += updated
+		s.Added += fresh[src] - updated
+		stale[src] -= updated
+	}
+	for _, n := range stale {
+		s.Removed += n
+	}
+
+	// Number the chunks in their new order.
+	present := map[ChunkRef]int{}
+	for i, c := range vectorizedChunks {
+		vectorizedChunks[i].Id = i
+		vectorizedChunks[i].Sources = []string{c.Source}
+		present[ChunkRef{Source: c.Source, Hash: c.Hash}] = i
+	}
+
+	// Record the state of every source and rebuild the sources of the
+	// chunks from them. A source that was merged into a chunk that is no
+	// longer in the index, because its own source changed or is pending,
+	// has lost content, so it is fetched again by the next ingestion.
+	states := map[string]SourceState{}
+	for _, src := range sources {
+		name := src.Name()
+
+		state := SourceState{Version: current[name]}
+		for _, ref := range refs[name] {
+			i, exists := present[ref]
+			if !exists {
+				state.Version = ""
+				continue
+			}
+			state.Chunks = append(state.Chunks, ref)
+			if !slices.Contains(vectorizedChunks[i].Sources, name) {
+				vectorizedChunks[i].Sources = append(vectorizedChunks[i].Sources, name)
+			}
+		}
+		states[name] = state
+	}
+	for i, c := range vectorizedChunks {
+		vectorizedChunks[i].SourceVersion = states[c.Source].Version
+	}
+
+	idx := Index{Chunks: vectorizedChunks, Sources: states}
+	if embedErr != nil {
+		return idx, s, embedErr
+	}
+	return idx, s, nil
+}
+
+// addRef adds a reference to a list of references, unless it is in the
+// list already.
+func addRef(refs []ChunkRef, ref ChunkRef) []ChunkRef {
+	if slices.Contains(refs, ref) {
+		return refs
+	}
+	return append(refs, ref)
+}
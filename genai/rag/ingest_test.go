@@ -0,0 +1,219 @@
+package rag_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/llm/llmtest"
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
+	"github.com/predictionguard/go-client"
+)
+
+const (
+	pageA = "<html><body><p>Goroutines are lightweight threads managed by the Go runtime.</p></body></html>"
+	pageB = "<html><body><p>Channels are the pipes that connect concurrent goroutines.</p></body></html>"
+)
+
+// pages serves websites whose content can be changed between ingestions.
+type pages struct {
+	mu    sync.Mutex
+	pages map[string]string
+}
+
+func (p *pages) set(path string, page string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pages[path] = page
+}
+
+func (p *pages) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	page, exists := p.pages[r.URL.Path]
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprint(w, page)
+}
+
+// ingest runs an ingestion of the sources on the index saved at path, and
+// saves the index it returns.
+func ingest(t *testing.T, fake *llmtest.Client, path string, sources []rag.Source) (rag.Index, rag.Summary) {
+	t.Helper()
+
+	previous, err := rag.LoadIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ing := rag.Ingester{Embedder: rag.Embedder{Client: fake}, Similarity: 0.95}
+	idx, summary, err := ing.Ingest(context.Background(), previous, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	return idx, summary
+}
+
+func TestIngestMergedSource(t *testing.T) {
+	site := &pages{pages: map[string]string{"/a": pageA, "/b": pageA}}
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	a, b := srv.URL+"/a", srv.URL+"/b"
+	sources := []rag.Source{{Website: a}, {Website: b}}
+	fake := &llmtest.Client{}
+	path := filepath.Join(t.TempDir(), "chunks.json")
+
+	// The chunks of b are all merged into the chunks of a, yet b gets a
+	// version, and the chunks stand for both.
+	idx, summary := ingest(t, fake, path, sources)
+	if summary.Added == 0 || summary.Duplicates != summary.Added {
+		t.Fatalf("got summary %s, want every chunk of b merged into a", summary)
+	}
+	if idx.Sources[b].Version == "" || len(idx.Sources[b].Chunks) != summary.Added {
+		t.Errorf("got state %+v of b, want a version and the chunks of a", idx.Sources[b])
+	}
+	for _, c := range idx.Chunks {
+		if c.Source != a || !slices.Equal(c.Sources, []string{a, b}) {
+			t.Errorf("got chunk of %s for %v, want a chunk of a for a and b", c.Source, c.Sources)
+		}
+	}
+
+	// Neither source changed, so nothing is embedded again and the chunks
+	// keep standing for both.
+	embedded := len(fake.CallsOf(llm.OpEmbedding))
+	idx, summary = ingest(t, fake, path, sources)
+	if n := len(fake.CallsOf(llm.OpEmbedding)) - embedded; n != 0 {
+		t.Errorf("embedded %d chunks of unchanged sources, want 0", n)
+	}
+	if summary.Added != 0 || summary.Removed != 0 || summary.Unchanged != len(idx.Chunks) {
+		t.Errorf("got summary %s, want every chunk unchanged", summary)
+	}
+	for _, c := range idx.Chunks {
+		if !slices.Equal(c.Sources, []string{a, b}) {
+			t.Errorf("got chunk for %v, want it for a and b", c.Sources)
+		}
+	}
+
+	// Once a changes, the content of b is no longer in the index, so b
+	// loses its version and is fetched again by the next ingestion.
+	site.set("/a", pageB)
+	idx, _ = ingest(t, fake, path, sources)
+	if state := idx.Sources[b]; state.Version != "" || len(state.Chunks) != 0 {
+		t.Errorf("got state %+v of b, want no version and no chunks", state)
+	}
+	for _, c := range idx.Chunks {
+		if !slices.Equal(c.Sources, []string{a}) {
+			t.Errorf("got chunk for %v, want it for a only", c.Sources)
+		}
+	}
+
+	embedded = len(fake.CallsOf(llm.OpEmbedding))
+	idx, summary = ingest(t, fake, path, sources)
+	if summary.Added == 0 || len(fake.CallsOf(llm.OpEmbedding)) == embedded {
+		t.Errorf("got summary %s, want the chunks of b embedded", summary)
+	}
+	if idx.Sources[b].Version == "" {
+		t.Errorf("got no version of b, want the one it was fetched at")
+	}
+	for _, c := range idx.Chunks {
+		if !slices.Equal(c.Sources, []string{c.Source}) {
+			t.Errorf("got chunk of %s for %v, want it for its own source", c.Source, c.Sources)
+		}
+	}
+}
+
+// flaky embeds with a fake client until its embeddings run out.
+type flaky struct {
+	*llmtest.Client
+	embeddings int
+}
+
+func (f *flaky) Embedding(ctx context.Context, input []client.EmbeddingInput) (client.Embedding, error) {
+	if f.embeddings == 0 {
+		return client.Embedding{}, errors.New("service unavailable")
+	}
+	f.embeddings--
+	return f.Client.Embedding(ctx, input)
+}
+
+func TestIngestPartial(t *testing.T) {
+	site := &pages{pages: map[string]string{"/a": pageA, "/b": pageB}}
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	sources := []rag.Source{{Website: srv.URL + "/a"}, {Website: srv.URL + "/b"}}
+	path := filepath.Join(t.TempDir(), "chunks.json")
+
+	// Embedding fails after the chunk of a, so the index holds that chunk
+	// and is worth saving.
+	ing := rag.Ingester{Embedder: rag.Embedder{Client: &flaky{Client: &llmtest.Client{}, embeddings: 1}}, Similarity: 0.95}
+	idx, summary, err := ing.Ingest(context.Background(), rag.Index{}, sources)
+	var partial *rag.PartialError
+	if !errors.As(err, &partial) {
+		t.Fatalf("got error %v, want a *rag.PartialError", err)
+	}
+	if partial.Pending != 1 || summary.Pending != 1 || len(idx.Chunks) != 1 {
+		t.Fatalf("got %d chunk(s) and %d pending, want 1 of each", len(idx.Chunks), partial.Pending)
+	}
+	if err := idx.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next ingestion only embeds the pending chunk.
+	fake := &llmtest.Client{}
+	idx, summary = ingest(t, fake, path, sources)
+	if n := len(fake.CallsOf(llm.OpEmbedding)); n != 1 {
+		t.Errorf("embedded %d chunks, want only the pending one", n)
+	}
+	if summary.Added != 1 || summary.Unchanged != 1 || len(idx.Chunks) != 2 {
+		t.Errorf("got summary %s, want the pending chunk added", summary)
+	}
+}
+
+func TestIngestFailure(t *testing.T) {
+	srv := httptest.NewServer(&pages{pages: map[string]string{}})
+	defer srv.Close()
+
+	// A source that cannot be fetched fails the ingestion as a whole.
+	ing := rag.Ingester{Embedder: rag.Embedder{Client: &llmtest.Client{}}}
+	_, _, err := ing.Ingest(context.Background(), rag.Index{}, []rag.Source{{Website: srv.URL + "/missing"}})
+	var partial *rag.PartialError
+	if err == nil || errors.As(err, &partial) {
+		t.Errorf("got error %v, want a failure that is not partial", err)
+	}
+}
+
+func TestIngestDimension(t *testing.T) {
+	site := &pages{pages: map[string]string{"/a": pageA}}
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	sources := []rag.Source{{Website: srv.URL + "/a"}}
+	path := filepath.Join(t.TempDir(), "chunks.json")
+	ingest(t, &llmtest.Client{Dimensions: 8}, path, sources)
+
+	// A changed chunk embedded by another model does not fit the index.
+	site.set("/a", pageB)
+	previous, err := rag.LoadIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ing := rag.Ingester{Embedder: rag.Embedder{Client: &llmtest.Client{Dimensions: 16}}, Similarity: 0.95}
+	if _, _, err := ing.Ingest(context.Background(), previous, sources); err == nil {
+		t.Error("got no error, want the dimensions of the index and the model to differ")
+	}
+}
@@ -0,0 +1,113 @@
+package rag
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters: k1 saturates the weight of repeated terms and b
+// normalizes it by the length of a chunk.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// terms splits a text into lowercase words of letters and digits.
+func terms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// KeywordSearch scores the chunks by the words they share with a query,
+// with BM25, and returns the k best, best first. Chunks that share no
+// word with the query are left out, so exact terms like identifiers can
+// be found even when their embeddings are not similar.
+func KeywordSearch(chunks VectorizedChunks, query string, k int) []Result {
+	words := terms(query)
+	if len(words) == 0 || len(chunks) == 0 {
+		return []Result{}
+	}
+
+	counts := make([]map[string]int, len(chunks))
+	lengths := make([]int, len(chunks))
+	frequency := map[string]int{}
+	var total int
+	for i, c := range chunks {
+		counts[i] = map[string]int{}
+		for _, term := range terms(c.Chunk) {
+			counts[i][term]++
+			lengths[i]++
+		}
+		for term := range counts[i] {
+			frequency[term]++
+		}
+		total += lengths[i]
+	}
+	avg := float64(total) / float64(len(chunks))
+
+	results := []Result{}
+	for i, c := range chunks {
+		var score float64
+		for _, word := range words {
+			tf := float64(counts[i][word])
+			if tf == 0 {
+				continue
+			}
+			n := float64(frequency[word])
+			idf := math.Log(1 + (float64(len(chunks))-n+0.5)/(n+0.5))
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(lengths[i])/avg))
+		}
+		if score > 0 {
+			results = append(results, Result{Chunk: c, Similarity: score})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Similarity > results[j].Similarity
+	})
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// rrfK dampens the weight of the top ranks in reciprocal rank fusion.
+const rrfK = 60
+
+// Fuse merges the results of searches, like a vector and a keyword
+// search, by reciprocal rank fusion and returns the k best. A chunk found
+// by several searches ranks higher than one found by one. The Similarity
+// of a fused result is its fusion score, not a cosine similarity.
+func Fuse(k int, searches ...[]Result) []Result {
+	scores := map[string]float64{}
+	chunks := map[string]VectorizedChunk{}
+	var order []string
+	for _, results := range searches {
+		for rank, result := range results {
+			key := result.Chunk.Hash
+			if key == "" {
+				key = HashChunk(result.Chunk.Chunk)
+			}
+			if _, exists := chunks[key]; !exists {
+				chunks[key] = result.Chunk
+				order = append(order, key)
+			}
+			scores[key] += 1 / float64(rrfK+rank+1)
+		}
+	}
+
+	results := make([]Result, len(order))
+	for i, key := range order {
+		results[i] = Result{Chunk: chunks[key], Similarity: scores[key]}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Similarity > results[j].Similarity
+	})
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
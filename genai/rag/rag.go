@@ -0,0 +1,356 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/predictionguard/go-client"
+)
+
+// ImageQuestion is asked about an image that is queried without text.
+const ImageQuestion = "What does the documentation say about what is shown in this image?"
+
+// Names of the prompts a pipeline uses by default: the system prompt, and
+// the prompt of a question about the context, with the variables context
+// and question.
+const (
+	SystemPrompt = "system"
+	QAPrompt     = "qa"
+)
+
+// RAG answers questions from the chunks of an index with a chat model.
+// The Embedding model defaults to the one of the API. The prompts are
+// taken from Prompts, the default ones if nil, by the names in System and
+// QA, SystemPrompt and QAPrompt if empty. The worked examples FewShot
+// selects for a question, if any, come between the system prompt and the
+// conversation. The Timeout bounds how long an answer can take to stream.
+// With a Semantic cache, questions outside of a conversation that are
+// near identical to one answered before get the same answer. With a
+// Refusal detector, a question whose answer is a refusal is asked again
+// with the context of each of the Escalations in turn, until one is
+// answered.
+type RAG struct {
+	Client      llm.Client
+	Chunks      VectorizedChunks
+	Model       llm.ModelSpec
+	Embedding   llm.ModelSpec
+	TopK        int
+	MaxTokens   int
+	Temperature float32
+	Timeout     time.Duration
+	Semantic    *SemanticCache
+	Prompts     *prompt.Set
+	System      string
+	QA          string
+	FewShot     FewShot
+	Refusal     *Refusal
+	Escalations []Escalation
+}
+
+// Response is the answer to a question and the chunks it is based on.
+// The FinishReason is "length" for an answer cut short by MaxTokens. The
+// Attempts are the escalation path of a question whose first answer was
+// a refusal, and are only set when a Refusal detector is.
+type Response struct {
+	Question     string
+	Answer       string
+	FinishReason string
+	Refused      bool
+	Usage        llm.Usage
+	Results      []Result
+	Attempts     []Attempt
+}
+
+// Search embeds a query, which can refer to an image, and returns the
+// chunks most similar to it.
+func (r RAG) Search(ctx context.Context, query string) ([]Result, error) {
+	vector, err := r.embed(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return Search(r.Chunks, vector, r.TopK)
+}
+
+// embed returns the vector of a query.
+func (r RAG) embed(ctx context.Context, query string) ([]float64, error) {
+	image, text := ParseQuery(query)
+
+	embedder := Embedder{Client: r.Client, Model: r.Embedding}
+	return embedder.Embed(ctx, image, text)
+}
+
+// Context joins the chunks of the search results into the context for a
+// prompt.
+func Context(results []Result) string {
+	chunks := make([]string, len(results))
+	for i, result := range results {
+		chunks[i] = result.Chunk.Chunk
+	}
+	return strings.Join(chunks, "\n\n")
+}
+
+// Answer streams the answer to a question, based on the search results,
+// to w and returns the full answer. The history holds the previous turns
+// of a conversation, if any.
+func (r RAG) Answer(ctx context.Context, question string, results []Result, history []client.ChatInputMessage, w io.Writer) (llm.Message, error) {
+	if err := r.Model.Supports(llm.OpChat); err != nil {
+		return llm.Message{}, err
+	}
+
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	messages, err := r.messages(ctx, question, results, history)
+	if err != nil {
+		return llm.Message{}, err
+	}
+
+	input := client.ChatSSEInput{
+		Model:       r.Model.Model,
+		Messages:    messages,
+		MaxTokens:   r.MaxTokens,
+		Temperature: r.Temperature,
+	}
+
+	var answer llm.Message
+	for event, err := range llm.Stream(ctx, r.Client, input) {
+		if err != nil {
+			return answer, fmt.Errorf("chat: %w", err)
+		}
+		fmt.Fprint(w, event.Delta)
+		answer.Add(event)
+	}
+
+	return answer, nil
+}
+
+// messages builds the messages of a chat that answers a question from the
+// search results.
+func (r RAG) messages(ctx context.Context, question string, results []Result, history []client.ChatInputMessage) ([]client.ChatInputMessage, error) {
+	prompts := r.Prompts
+	if prompts == nil {
+		prompts = prompt.Default()
+	}
+
+	system, err := prompts.Render(cmp.Or(r.System, SystemPrompt), nil)
+	if err != nil {
+		return nil, err
+	}
+	qa, err := prompts.Render(cmp.Or(r.QA, QAPrompt), map[string]any{
+		"context":  Context(results),
+		"question": question,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	shots, err := r.FewShot.Messages(ctx, question, prompts, r.QA)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []client.ChatInputMessage{
+		{
+			Role:    client.Roles.System,
+			Content: system,
+		},
+	}
+	messages = append(messages, shots...)
+	messages = append(messages, history...)
+	messages = append(messages, client.ChatInputMessage{
+		Role:    client.Roles.User,
+		Content: qa,
+	})
+
+	// Fail early on a prompt the model cannot read, rather than on a
+	// stream cut short.
+	if tokens := llm.EstimateMessagesTokens(messages); r.Model.ContextLength > 0 && tokens > r.Model.ContextLength {
+		return nil, fmt.Errorf("prompt of about %d tokens exceeds the context length of %s (%d)", tokens, r.Model.Name(), r.Model.ContextLength)
+	}
+
+	return messages, nil
+}
+
+// questionOf returns the question of a query. The model only reads text,
+// so a query about an image on its own asks about the image.
+func questionOf(query string) string {
+	_, question := ParseQuery(query)
+	if question == "" {
+		return ImageQuestion
+	}
+	return question
+}
+
+// Ask searches the index for a query, which can refer to an image, and
+// streams the answer to w.
+func (r RAG) Ask(ctx context.Context, query string, history []client.ChatInputMessage, w io.Writer) (Response, error) {
+	vector, err := r.embed(ctx, query)
+	if err != nil {
+		return Response{}, err
+	}
+
+	semantic := r.Semantic != nil && len(history) == 0
+	if semantic {
+		if resp, hit := r.Semantic.Lookup(vector); hit {
+			fmt.Fprint(w, resp.Answer)
+			return resp, nil
+		}
+	}
+
+	results, err := Search(r.Chunks, vector, r.TopK)
+	if err != nil {
+		return Response{}, err
+	}
+
+	question := questionOf(query)
+
+	resp := Response{
+		Question: question,
+		Results:  results,
+	}
+	if r.Refusal != nil {
+		resp.Attempts = []Attempt{{Step: "search", Query: query, Chunks: len(results)}}
+	}
+
+	for i := 0; ; i++ {
+		final := r.Refusal == nil || i == len(r.Escalations)
+
+		answer, refused, err := r.attempt(ctx, question, resp.Results, history, w, final)
+		if err != nil {
+			return Response{}, err
+		}
+		resp.Answer = answer.Content
+		resp.FinishReason = answer.FinishReason
+		resp.Refused = refused
+		resp.Usage = resp.Usage.Add(answer.Usage)
+		if r.Refusal == nil {
+			break
+		}
+		resp.Attempts[i].Refused = refused
+		if !refused || final {
+			break
+		}
+
+		attempt, results, err := r.Escalations[i](ctx, r, query)
+		if err != nil {
+			return Response{}, fmt.Errorf("escalation after %s: %w", resp.Attempts[i].Step, err)
+		}
+		resp.Attempts = append(resp.Attempts, attempt)
+		resp.Results = results
+	}
+
+	// Only complete answers are worth serving again.
+	if semantic && resp.FinishReason == "stop" && !resp.Refused {
+		r.Semantic.Store(vector, resp)
+	}
+
+	return resp, nil
+}
+
+// attempt streams an answer to a question to w, and reports whether it is
+// a refusal. Unless the attempt is final, a refusal is held back, since
+// the question is asked again.
+func (r RAG) attempt(ctx context.Context, question string, results []Result, history []client.ChatInputMessage, w io.Writer, final bool) (llm.Message, bool, error) {
+	if r.Refusal == nil {
+		answer, err := r.Answer(ctx, question, results, history, w)
+		return answer, false, err
+	}
+
+	var held *holdback
+	if !final {
+		held = &holdback{w: w, sentence: llm.NormalizeAnswer(r.Refusal.Sentence)}
+		w = held
+	}
+
+	answer, err := r.Answer(ctx, question, results, history, w)
+	if err != nil {
+		return answer, false, err
+	}
+
+	refused, err := r.Refusal.Detect(ctx, answer.Content)
+	if err != nil {
+		return answer, false, fmt.Errorf("refusal: %w", err)
+	}
+
+	if held != nil {
+		switch {
+		case !refused:
+			err = held.Flush()
+		case held.flushed:
+			// A refusal in other words has been shown already.
+			_, err = io.WriteString(held.w, "\n\n")
+		}
+	}
+
+	return answer, refused, err
+}
+
+// Sample searches the index for a query like Ask, and answers it with the
+// consensus of the samples of the strategy instead of a single stream.
+// The strategy calls the Client of the pipeline if it has none.
+func (r RAG) Sample(ctx context.Context, query string, history []client.ChatInputMessage, sc llm.SelfConsistency) (Response, llm.Consensus, error) {
+	if err := r.Model.Supports(llm.OpChat); err != nil {
+		return Response{}, llm.Consensus{}, err
+	}
+
+	results, err := r.Search(ctx, query)
+	if err != nil {
+		return Response{}, llm.Consensus{}, err
+	}
+
+	question := questionOf(query)
+
+	messages, err := r.messages(ctx, question, results, history)
+	if err != nil {
+		return Response{}, llm.Consensus{}, err
+	}
+
+	if sc.Client == nil {
+		sc.Client = r.Client
+	}
+
+	input := client.ChatInput{
+		Model:       r.Model.Model,
+		Messages:    messages,
+		MaxTokens:   r.MaxTokens,
+		Temperature: r.Temperature,
+	}
+
+	consensus, err := sc.Chat(ctx, input)
+	if err != nil {
+		return Response{}, llm.Consensus{}, fmt.Errorf("chat: %w", err)
+	}
+
+	resp := Response{
+		Question: question,
+		Answer:   consensus.Answer,
+		Results:  results,
+	}
+
+	return resp, consensus, nil
+}
+
+// Factuality scores how well an answer is supported by the chunks it is
+// based on.
+func (r RAG) Factuality(ctx context.Context, results []Result, answer string) (float64, error) {
+	resp, err := r.Client.Factuality(ctx, Context(results), answer)
+	if err != nil {
+		return 0.0, fmt.Errorf("factuality: %w", err)
+	}
+	if len(resp.Checks) == 0 {
+		return 0.0, errors.New("factuality: no checks in response")
+	}
+
+	return resp.Checks[0].Score, nil
+}
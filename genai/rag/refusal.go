@@ -0,0 +1,259 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/dwhitena/go-genai-webinar/genai/llm"
+	"github.com/dwhitena/go-genai-webinar/genai/prompt"
+	"github.com/predictionguard/go-client"
+)
+
+// Names of the prompts of the refusal sentence the system prompt tells
+// the model to answer with, and of the rewrite of a question into a
+// search query, with the variable question.
+const (
+	RefusalPrompt = "refusal"
+	RewritePrompt = "rewrite"
+)
+
+// Refusal detects answers in which the model refuses to answer from the
+// context. An answer is a refusal when it contains the Sentence, ignoring
+// case, punctuation and spacing, or, with an Embedder, when the similarity
+// of its embedding to the one of the sentence reaches the Threshold. It is
+// safe for concurrent use.
+type Refusal struct {
+	Sentence  string
+	Embedder  *Embedder
+	Threshold float64
+
+	mu     sync.Mutex
+	vector []float64
+}
+
+// NewRefusal constructs a detector of the refusal sentence of the prompts,
+// the default ones if nil.
+func NewRefusal(prompts *prompt.Set) (*Refusal, error) {
+	if prompts == nil {
+		prompts = prompt.Default()
+	}
+
+	sentence, err := prompts.Render(RefusalPrompt, nil)
+	if err != nil {
+		return nil, err
+	}
+	if llm.NormalizeAnswer(sentence) == "" {
+		return nil, errors.New("refusal sentence is empty")
+	}
+
+	return &Refusal{Sentence: sentence}, nil
+}
+
+// Detect reports whether an answer is a refusal.
+func (r *Refusal) Detect(ctx context.Context, answer string) (bool, error) {
+	normalized := llm.NormalizeAnswer(answer)
+	if normalized == "" {
+		return false, nil
+	}
+	if strings.Contains(normalized, llm.NormalizeAnswer(r.Sentence)) {
+		return true, nil
+	}
+	if r.Embedder == nil || r.Threshold <= 0 {
+		return false, nil
+	}
+
+	sentence, err := r.sentenceVector(ctx)
+	if err != nil {
+		return false, err
+	}
+	vector, err := r.Embedder.Embed(ctx, "", answer)
+	if err != nil {
+		return false, err
+	}
+	similarity, err := CosineSimilarity(vector, sentence)
+	if err != nil {
+		return false, err
+	}
+
+	return similarity >= r.Threshold, nil
+}
+
+// sentenceVector returns the embedding of the sentence, embedding it on
+// first use.
+func (r *Refusal) sentenceVector(ctx context.Context) ([]float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.vector == nil {
+		vector, err := r.Embedder.Embed(ctx, "", r.Sentence)
+		if err != nil {
+			return nil, err
+		}
+		r.vector = vector
+	}
+	return r.vector, nil
+}
+
+// Attempt is an attempt at answering a question: the step that retrieved
+// the context, the query it searched for, the number of chunks found and
+// whether the answer was a refusal.
+type Attempt struct {
+	Step    string `json:"step"`
+	Query   string `json:"query"`
+	Chunks  int    `json:"chunks"`
+	Refused bool   `json:"refused"`
+}
+
+// String implements the fmt.Stringer interface.
+func (a Attempt) String() string {
+	outcome := "answered"
+	if a.Refused {
+		outcome = "refused"
+	}
+	return fmt.Sprintf("%s %q (%d chunks): %s", a.Step, a.Query, a.Chunks, outcome)
+}
+
+// Escalation retrieves the context of another attempt at a query whose
+// answer was a refusal.
+type Escalation func(ctx context.Context, r RAG, query string) (Attempt, []Result, error)
+
+// DefaultEscalations are the escalations of a refusal, tried in order:
+// three times the chunks, a rewritten query and a hybrid search.
+var DefaultEscalations = []Escalation{Widen(3), Rewrite(), Hybrid()}
+
+// Widen searches for the query again, for factor times the chunks.
+func Widen(factor int) Escalation {
+	return func(ctx context.Context, r RAG, query string) (Attempt, []Result, error) {
+		r.TopK = max(r.TopK, 1) * max(factor, 1)
+
+		results, err := r.Search(ctx, query)
+		if err != nil {
+			return Attempt{}, nil, err
+		}
+		return Attempt{Step: "widen", Query: query, Chunks: len(results)}, results, nil
+	}
+}
+
+// Rewrite has the model rewrite the text of the query into a search query
+// with the rewrite prompt, and searches for it instead.
+func Rewrite() Escalation {
+	return func(ctx context.Context, r RAG, query string) (Attempt, []Result, error) {
+		image, text := ParseQuery(query)
+
+		rewritten, err := r.rewrite(ctx, cmp.Or(text, ImageQuestion))
+		if err != nil {
+			return Attempt{}, nil, fmt.Errorf("rewrite: %w", err)
+		}
+		if image != "" {
+			rewritten = image + " " + rewritten
+		}
+
+		results, err := r.Search(ctx, rewritten)
+		if err != nil {
+			return Attempt{}, nil, err
+		}
+		return Attempt{Step: "rewrite", Query: rewritten, Chunks: len(results)}, results, nil
+	}
+}
+
+// rewrite has the model rewrite a question into a search query.
+func (r RAG) rewrite(ctx context.Context, question string) (string, error) {
+	prompts := r.Prompts
+	if prompts == nil {
+		prompts = prompt.Default()
+	}
+
+	content, err := prompts.Render(RewritePrompt, map[string]any{"question": question})
+	if err != nil {
+		return "", err
+	}
+
+	input := client.ChatInput{
+		Model: r.Model.Model,
+		Messages: []client.ChatInputMessage{
+			{Role: client.Roles.User, Content: content},
+		},
+		MaxTokens: 100,
+	}
+	resp, err := r.Client.Chat(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("no choices in response")
+	}
+
+	// Models like to quote the query or put it on a line of its own.
+	rewritten := strings.TrimSpace(resp.Choices[0].Message.Content)
+	if line, _, found := strings.Cut(rewritten, "\n"); found {
+		rewritten = line
+	}
+	rewritten = strings.Trim(rewritten, "\"' ")
+	if rewritten == "" {
+		return "", errors.New("empty query")
+	}
+
+	return rewritten, nil
+}
+
+// Hybrid searches for the query by both the embeddings and the words of
+// the chunks, for twice the chunks of each, and fuses the results.
+func Hybrid() Escalation {
+	return func(ctx context.Context, r RAG, query string) (Attempt, []Result, error) {
+		k := max(r.TopK, 1) * 2
+
+		vector, err := r.embed(ctx, query)
+		if err != nil {
+			return Attempt{}, nil, err
+		}
+		semantic, err := Search(r.Chunks, vector, k)
+		if err != nil {
+			return Attempt{}, nil, err
+		}
+		_, text := ParseQuery(query)
+		keyword := KeywordSearch(r.Chunks, text, k)
+
+		results := Fuse(k, semantic, keyword)
+		return Attempt{Step: "hybrid", Query: query, Chunks: len(results)}, results, nil
+	}
+}
+
+// holdback writes an answer through to w once it is clear that it is not
+// the refusal sentence, so a refusal that is retried is never shown.
+type holdback struct {
+	w        io.Writer
+	sentence string
+	held     strings.Builder
+	flushed  bool
+}
+
+// Write implements the io.Writer interface.
+func (h *holdback) Write(p []byte) (int, error) {
+	if h.flushed {
+		return h.w.Write(p)
+	}
+
+	h.held.Write(p)
+	if strings.HasPrefix(h.sentence, llm.NormalizeAnswer(h.held.String())) {
+		return len(p), nil
+	}
+	if err := h.Flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes what is held back.
+func (h *holdback) Flush() error {
+	if h.flushed {
+		return nil
+	}
+	h.flushed = true
+	_, err := io.WriteString(h.w, h.held.String())
+	return err
+}
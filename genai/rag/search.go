@@ -0,0 +1,72 @@
+package rag
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CosineSimilarity calculates the cosine similarity between two vectors.
+func CosineSimilarity(a []float64, b []float64) (cosine float64, err error) {
+	count := 0
+	lengthA := len(a)
+	lengthB := len(b)
+	if lengthA > lengthB {
+		count = lengthA
+	} else {
+		count = lengthB
+	}
+	sumA := 0.0
+	s1 := 0.0
+	s2 := 0.0
+	for k := 0; k < count; k++ {
+		if k >= lengthA {
+			s2 += math.Pow(b[k], 2)
+			continue
+		}
+		if k >= lengthB {
+			s1 += math.Pow(a[k], 2)
+			continue
+		}
+		sumA += a[k] * b[k]
+		s1 += math.Pow(a[k], 2)
+		s2 += math.Pow(b[k], 2)
+	}
+	if s1 == 0 || s2 == 0 {
+		return 0.0, errors.New("vectors should not be null (all zeros)")
+	}
+	return sumA / (math.Sqrt(s1) * math.Sqrt(s2)), nil
+}
+
+// Result is a chunk found by a search and its similarity to the query.
+type Result struct {
+	Chunk      VectorizedChunk
+	Similarity float64
+}
+
+// Search through the vectorized chunks to find the k chunks most similar
+// to a vector, most similar first. The vector has to have the dimension of
+// the chunks, or it was not embedded by the model of the index.
+func Search(chunks VectorizedChunks, vector []float64, k int) ([]Result, error) {
+	if dim := chunks.Dimension(); dim > 0 && len(vector) != dim {
+		return nil, fmt.Errorf("query has %d dimensions, the index %d: it was embedded with another model", len(vector), dim)
+	}
+
+	results := []Result{}
+	for _, c := range chunks {
+		similarity, err := CosineSimilarity(c.Vector, vector)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, Result{Chunk: c, Similarity: similarity})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Similarity > results[j].Similarity
+	})
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
@@ -0,0 +1,28 @@
+package rag_test
+
+import (
+	"testing"
+
+	"github.com/dwhitena/go-genai-webinar/genai/rag"
+)
+
+func TestSearch(t *testing.T) {
+	chunks := rag.VectorizedChunks{
+		{Id: 0, Chunk: "far", Vector: []float64{0, 1, 0}},
+		{Id: 1, Chunk: "near", Vector: []float64{1, 0.1, 0}},
+		{Id: 2, Chunk: "nearest", Vector: []float64{1, 0, 0}},
+	}
+
+	results, err := rag.Search(chunks, []float64{1, 0, 0}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Chunk.Chunk != "nearest" || results[1].Chunk.Chunk != "near" {
+		t.Errorf("got results %+v, want nearest and near", results)
+	}
+
+	// A query embedded by another model does not fit the index.
+	if _, err := rag.Search(chunks, []float64{1, 0}, 2); err == nil {
+		t.Error("got no error, want the dimensions of the query and the index to differ")
+	}
+}
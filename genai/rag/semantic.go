@@ -0,0 +1,58 @@
+package rag
+
+import (
+	"sync"
+)
+
+// semanticCacheSize is the number of answers a SemanticCache keeps.
+const semanticCacheSize = 1000
+
+// SemanticCache serves the answers of questions that are near identical
+// to ones answered before, by the similarity of their embeddings. It is
+// safe for concurrent use.
+type SemanticCache struct {
+	Threshold float64
+
+	mu      sync.Mutex
+	entries []semanticEntry
+}
+
+// semanticEntry is an answered question.
+type semanticEntry struct {
+	vector   []float64
+	response Response
+}
+
+// Lookup returns the answer of the most similar question, if its
+// similarity reaches the threshold.
+func (c *SemanticCache) Lookup(vector []float64) (Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var best Response
+	bestSimilarity := -1.0
+	for _, entry := range c.entries {
+		similarity, err := CosineSimilarity(vector, entry.vector)
+		if err != nil {
+			continue
+		}
+		if similarity > bestSimilarity {
+			best = entry.response
+			bestSimilarity = similarity
+		}
+	}
+
+	return best, bestSimilarity >= c.Threshold
+}
+
+// Store keeps the answer of a question, dropping the oldest answer once
+// the cache is full.
+func (c *SemanticCache) Store(vector []float64, resp Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = append(c.entries, semanticEntry{vector: vector, response: resp})
+	if len(c.entries) > semanticCacheSize {
+		c.entries = c.entries[1:]
+	}
+}
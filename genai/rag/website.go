@@ -0,0 +1,185 @@
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ErrNotModified is returned when a source has not changed since the
+// version that was indexed previously.
+var ErrNotModified = errors.New("source not modified")
+
+// CharacterTextSplitter takes in a string and splits the string into
+// chunks of a given size (split on whitespace) with an overlap of a
+// given size of tokens (split on whitespace).
+func CharacterTextSplitter(text string, splitSize int, overlapSize int) []string {
+
+	// Create a slice to hold the chunks.
+	chunks := []string{}
+
+	// Split the text into tokens based on whitespace.
+	tokens := strings.Split(text, " ")
+
+	// Loop over the tokens creating chunks of size splitSize with an
+	// overlap of overlapSize.
+	for i := 0; i < len(tokens); i += splitSize - overlapSize {
+		end := i + splitSize - overlapSize
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		chunks = append(chunks, strings.Join(tokens[i:end], " "))
+	}
+	return chunks
+}
+
+// websiteVersion identifies the version of a downloaded website. The ETag
+// or Last-Modified headers are preferred, so later downloads can be made
+// conditional, and the hash of the content is used otherwise.
+func websiteVersion(header http.Header, content []byte) string {
+	if etag := header.Get("ETag"); etag != "" {
+		return "etag:" + etag
+	}
+	if modified := header.Get("Last-Modified"); modified != "" {
+		return "modified:" + modified
+	}
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// imageChunks finds the images of a website that appear in the part of
+// the markdown being indexed, and turns each into an image chunk whose
+// metadata holds the alt text and caption to embed with the image.
+func imageChunks(page *url.URL, html string, markdown string) (VectorizedChunks, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := VectorizedChunks{}
+	seen := map[string]bool{}
+	doc.Find("img").Each(func(_ int, img *goquery.Selection) {
+		src, _ := img.Attr("src")
+		if src == "" || strings.HasPrefix(src, "data:") || !strings.Contains(markdown, src) {
+			return
+		}
+
+		// Resolve the image against the website it came from.
+		ref, err := url.Parse(src)
+		if err != nil {
+			return
+		}
+		imageURL := page.ResolveReference(ref).String()
+		if seen[imageURL] {
+			return
+		}
+		seen[imageURL] = true
+
+		// Describe the image with its alt text and any figure caption.
+		alt := strings.TrimSpace(img.AttrOr("alt", ""))
+		caption := strings.Join(strings.Fields(img.Closest("figure").Find("figcaption").Text()), " ")
+		description := strings.TrimSpace(alt + "\n" + caption)
+
+		chunk := fmt.Sprintf("Image: %s", imageURL)
+		if alt != "" {
+			chunk += fmt.Sprintf("\nAlt text: %s", alt)
+		}
+		if caption != "" {
+			chunk += fmt.Sprintf("\nCaption: %s", caption)
+		}
+
+		chunks = append(chunks, VectorizedChunk{
+			Chunk:    chunk,
+			Metadata: description,
+			Type:     TypeImage,
+			ImageURL: imageURL,
+		})
+	})
+	return chunks, nil
+}
+
+// WebsiteChunks loads in a website and splits it into text chunks with an
+// optional start string and end string, followed by a chunk for each image
+// in between. The version of the website that was indexed previously (if
+// any) is used to skip websites that have not changed, in which case
+// ErrNotModified is returned.
+func WebsiteChunks(ctx context.Context, website string, start string, end string, version string) (VectorizedChunks, string, error) {
+
+	converter := md.NewConverter("", true, nil)
+
+	// Only download the website if it changed since the previous version.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, website, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	switch {
+	case strings.HasPrefix(version, "etag:"):
+		req.Header.Set("If-None-Match", strings.TrimPrefix(version, "etag:"))
+	case strings.HasPrefix(version, "modified:"):
+		req.Header.Set("If-Modified-Since", strings.TrimPrefix(version, "modified:"))
+	}
+
+	// Download the website.
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, version, ErrNotModified
+	default:
+		return nil, "", fmt.Errorf("downloading %s: %s", website, res.Status)
+	}
+
+	// Compare the downloaded version with the previous one.
+	newVersion := websiteVersion(res.Header, content)
+	if newVersion == version {
+		return nil, version, ErrNotModified
+	}
+	html := string(content)
+
+	// Convert the html to markdown for convenience.
+	markdown, err := converter.ConvertString(html)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Split the markdown string on any provided start and end strings.
+	if start != "" {
+		markdownRemaining := strings.Split(markdown, start)[1:]
+		markdown = strings.Join(markdownRemaining, "")
+	}
+	if end != "" {
+		markdown = strings.Split(markdown, end)[0]
+	}
+
+	// Split the text into reasonable size chunks with an overlap.
+	chunks := VectorizedChunks{}
+	for _, text := range CharacterTextSplitter(markdown, 100, 10) {
+		chunks = append(chunks, VectorizedChunk{Chunk: text, Metadata: text, Type: TypeText})
+	}
+
+	// Add the images, so answers can point to the relevant diagrams.
+	images, err := imageChunks(res.Request.URL, html, markdown)
+	if err != nil {
+		return nil, "", err
+	}
+	chunks = append(chunks, images...)
+
+	return chunks, newVersion, nil
+}